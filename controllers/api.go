@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gogol_analytics/database"
+	"gogol_analytics/export"
+	"gogol_analytics/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Statistics API (/api/v1/). Every endpoint accepts the parameters read by apiQuery
+// and answers JSON; see docs/api.md.
+
+// RealtimeWindow is how far back /api/v1/realtime looks for active visitors
+const RealtimeWindow = 5 * time.Minute
+
+// apiRange echoes the range of a response; open ends are null
+type apiRange struct {
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+type apiPoint struct {
+	Time              time.Time `json:"time"`
+	Views             int       `json:"views"`
+	Visitors          int       `json:"visitors"`
+	NewVisitors       int       `json:"new_visitors"`
+	ReturningVisitors int       `json:"returning_visitors"`
+	Bots              int       `json:"bots"`
+	ImportedVisitors  int       `json:"imported_visitors"`
+}
+
+type apiRow struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	Title string `json:"title,omitempty"`
+}
+
+// apiQuery reads the parameters shared by the /api/v1 endpoints:
+//   - site: website ID; every website when omitted
+//   - range: "24h", "7d", "30d" (default), "month", "last_month", "ytd" or "all", or from
+//     and to as inclusive YYYY-MM-DD days
+//   - tz: IANA time zone of days and buckets; defaults to the website's
+//   - filter: "dimension:op:value" (op: eq, neq, contains or regex) or "dimension:value",
+//     repeatable; all filters must match
+func apiQuery(r *http.Request) (database.Query, error) {
+	params := r.URL.Query()
+
+	site := params.Get("site")
+	if site != "" {
+		if _, ok, err := database.GetWebsite(site); err != nil || !ok {
+			return database.Query{}, fmt.Errorf("unknown site %q", site)
+		}
+	}
+	loc, err := viewerLocation(params.Get("tz"), site)
+	if err != nil {
+		return database.Query{}, err
+	}
+
+	q := database.Query{Location: loc}
+	switch timeRange := params.Get("range"); {
+	case params.Get("from") != "" || params.Get("to") != "":
+		if q.From, q.To, err = export.ParseRange(params.Get("from"), params.Get("to"), loc); err != nil {
+			return q, err
+		}
+	case timeRange == "all":
+	case timeRange == "":
+		q, _ = database.RangeQuery("30d", loc)
+	default:
+		if q, _, err = database.ResolveRange(timeRange, "", "", loc); err != nil {
+			return q, fmt.Errorf("unknown range %q", timeRange)
+		}
+	}
+	q.WebsiteID = site
+
+	for _, raw := range params["filter"] {
+		filter, err := database.ParseFilter(raw)
+		if err != nil {
+			return q, err
+		}
+		q.Filters = append(q.Filters, filter)
+	}
+	return q, nil
+}
+
+// apiLimit reads the limit parameter, bounded by max
+func apiLimit(r *http.Request, fallback, max int) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return fallback, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > max {
+		return 0, fmt.Errorf("limit must be between 1 and %d", max)
+	}
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(v)
+}
+
+func rangeOf(q database.Query) apiRange {
+	var rng apiRange
+	if !q.From.IsZero() {
+		rng.From = &q.From
+	}
+	if !q.To.IsZero() {
+		rng.To = &q.To
+	}
+	return rng
+}
+
+// APITimeseries returns the chart buckets of the range: ?interval=hour or day
+// (default: hour up to two days, day beyond)
+func APITimeseries(w http.ResponseWriter, r *http.Request) {
+	q, err := apiQuery(r)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// "all" and an empty from start at the first event, like the dashboard's "all"
+	if q.From.IsZero() {
+		all, _ := database.RangeQuery("all", q.Location)
+		q.From = all.From
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+
+	interval := r.URL.Query().Get("interval")
+	switch interval {
+	case "":
+		interval = "day"
+		if q.To.Sub(q.From) <= 48*time.Hour {
+			interval = "hour"
+		}
+	case "hour", "day":
+	default:
+		http.Error(w, "Bad request: interval must be hour or day", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.CheckChartRange(q, interval); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := database.GetChartData(q, interval)
+	if err != nil {
+		fmt.Printf("Error getting API timeseries: %v\n", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	data := make([]apiPoint, len(points))
+	for i, p := range points {
+		data[i] = apiPoint{
+			Time:              p.Time,
+			Views:             p.Views,
+			Visitors:          p.Visitors,
+			NewVisitors:       p.NewVisitors,
+			ReturningVisitors: p.ReturningVisitors,
+			Bots:              p.Bots,
+			ImportedVisitors:  p.ImportedVisitors,
+		}
+	}
+	writeJSON(w, map[string]any{"range": rangeOf(q), "interval": interval, "data": data})
+}
+
+// APITop returns the most frequent values of a dimension: ?dimension=page&limit=10
+func APITop(w http.ResponseWriter, r *http.Request) {
+	q, err := apiQuery(r)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := apiLimit(r, 10, 1000)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dimension := r.URL.Query().Get("dimension")
+	if _, ok := database.Dimensions[dimension]; !ok {
+		http.Error(w, fmt.Sprintf("Bad request: unknown dimension %q", dimension), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := database.GetTopStats(q.Plan(), dimension, limit)
+	if err != nil {
+		fmt.Printf("Error getting API stats for %s: %v\n", dimension, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	data := make([]apiRow, len(rows))
+	for i, row := range rows {
+		data[i] = apiRow{Key: row.Key, Count: row.Value, Title: row.Title}
+	}
+	writeJSON(w, map[string]any{"range": rangeOf(q), "dimension": dimension, "data": data})
+}
+
+// APIRealtime counts the human visitors seen in the last RealtimeWindow; range parameters are ignored
+func APIRealtime(w http.ResponseWriter, r *http.Request) {
+	q, err := apiQuery(r)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.From, q.To = time.Now().Add(-RealtimeWindow), time.Time{}
+
+	visitors, err := database.CountVisitors(q)
+	if err != nil {
+		fmt.Printf("Error counting realtime visitors: %v\n", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"visitors": visitors, "window_seconds": int(RealtimeWindow.Seconds())})
+}
+
+// APIEvents returns the latest raw events of the range, newest first: ?limit=100
+func APIEvents(w http.ResponseWriter, r *http.Request) {
+	q, err := apiQuery(r)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := apiLimit(r, 100, 1000)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := database.GetRecentEvents(q, limit)
+	if err != nil {
+		fmt.Printf("Error getting API events: %v\n", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []models.Event{}
+	}
+	writeJSON(w, map[string]any{"range": rangeOf(q), "data": events})
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"encoding/json"
+	"gogol_analytics/database"
+	"gogol_analytics/models"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	os.Setenv("DB_PATH", filepath.Join(t.TempDir(), "gogol_test.db"))
+	database.InitDB()
+	t.Cleanup(func() { database.DB.Close() })
+}
+
+func TestAPIQuery(t *testing.T) {
+	setupTestDB(t)
+
+	r := httptest.NewRequest("GET", "/api/v1/top?from=2024-01-01&to=2024-01-31&filter=country:France&filter=page:/a:b", nil)
+	q, err := apiQuery(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.From.Format("2006-01-02") != "2024-01-01" || q.To.Format("2006-01-02") != "2024-02-01" {
+		t.Errorf("unexpected range %v - %v", q.From, q.To)
+	}
+	if len(q.Filters) != 2 || q.Filters[0].Value != "France" || q.Filters[1].Dimension != "page" || q.Filters[1].Value != "/a:b" {
+		t.Errorf("unexpected filters %+v", q.Filters)
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/top", nil)
+	if q, err = apiQuery(r); err != nil || time.Since(q.From) < 29*24*time.Hour {
+		t.Errorf("expected the last 30 days by default, got %v (%v)", q.From, err)
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/top?range=7d&tz=Asia/Tokyo", nil)
+	if q, err = apiQuery(r); err != nil || q.From.Location().String() != "Asia/Tokyo" || q.From.Hour() != 0 {
+		t.Errorf("expected the range to start at midnight in Tokyo, got %v (%v)", q.From, err)
+	}
+
+	for _, bad := range []string{"range=1y", "filter=ip_hash:x", "filter=country", "from=01/02/2024", "tz=Mars/Olympus", "site=SITE_0"} {
+		if _, err := apiQuery(httptest.NewRequest("GET", "/api/v1/top?"+bad, nil)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestAPITimeseriesErrors(t *testing.T) {
+	setupTestDB(t)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		APITimeseries(w, httptest.NewRequest("GET", "/api/v1/timeseries?"+query, nil))
+		return w
+	}
+	if w := get("range=7d"); w.Code != 200 {
+		t.Errorf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get("from=2020-01-01&to=2024-12-31&interval=hour"); w.Code != 400 || !strings.Contains(w.Body.String(), "too many hour buckets") {
+		t.Errorf("expected 400 for too many buckets, got %d %s", w.Code, w.Body.String())
+	}
+
+	// "all" and an empty from start on the day of the first event
+	first := time.Now().AddDate(0, 0, -60)
+	if err := database.InsertEvents([]models.Event{{WebsiteID: "site", Timestamp: first, VisitorID: "a", NormalizedPath: "/"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"range=all&tz=UTC", "to=" + time.Now().Format("2006-01-02") + "&tz=UTC"} {
+		w := get(query)
+		var body struct {
+			Data []apiPoint `json:"data"`
+		}
+		if w.Code != 200 || json.Unmarshal(w.Body.Bytes(), &body) != nil || len(body.Data) == 0 {
+			t.Fatalf("%s: expected 200, got %d %s", query, w.Code, w.Body.String())
+		}
+		if day := body.Data[0].Time.Format("2006-01-02"); day != first.UTC().Format("2006-01-02") || body.Data[0].Views != 1 {
+			t.Errorf("%s: expected the first bucket on %s with the event, got %s: %+v", query, first.UTC().Format("2006-01-02"), day, body.Data[0])
+		}
+	}
+
+	// Database failures are not the client's fault and do not leak their message
+	database.DB.Exec("DROP TABLE visitors")
+	if w := get("range=7d"); w.Code != 500 || strings.TrimSpace(w.Body.String()) != "Database error" {
+		t.Errorf("expected 500 Database error, got %d %s", w.Code, w.Body.String())
+	}
+}
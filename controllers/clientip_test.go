@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets := parseTrustedProxies(" 10.0.0.0/8, 203.0.113.7 ,2001:db8::1,not-an-ip,")
+	want := []string{"10.0.0.0/8", "203.0.113.7/32", "2001:db8::1/128"}
+	if len(nets) != len(want) {
+		t.Fatalf("got %v, want %v", nets, want)
+	}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("entry %d: got %s, want %s", i, n, want[i])
+		}
+	}
+
+	defaults := parseTrustedProxies("")
+	if len(defaults) != 2 || defaults[0].String() != "127.0.0.0/8" || defaults[1].String() != "::1/128" {
+		t.Errorf("default trusted proxies: got %v, want loopback only", defaults)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	saved := trustedProxies
+	trustedProxies = parseTrustedProxies("127.0.0.1,10.0.0.0/8")
+	t.Cleanup(func() { trustedProxies = saved })
+
+	tests := []struct {
+		name   string
+		peer   string
+		header []string
+		want   string
+	}{
+		{"no header", "198.51.100.1:1234", nil, "198.51.100.1"},
+		{"untrusted peer spoofing", "198.51.100.1:1234", []string{"1.2.3.4"}, "198.51.100.1"},
+		{"trusted peer", "127.0.0.1:1234", []string{"203.0.113.5"}, "203.0.113.5"},
+		{"trusted chain", "127.0.0.1:1234", []string{"203.0.113.5, 10.0.0.2, 10.0.0.1"}, "203.0.113.5"},
+		{"chain over several headers", "127.0.0.1:1234", []string{"203.0.113.5", "10.1.0.1"}, "203.0.113.5"},
+		// The client can prepend anything; only the hop added by the last trusted proxy counts
+		{"spoofed prefix", "127.0.0.1:1234", []string{"1.2.3.4, 203.0.113.5, 10.0.0.1"}, "203.0.113.5"},
+		{"only trusted hops", "127.0.0.1:1234", []string{"10.0.0.1"}, "10.0.0.1"},
+		{"empty hops", "127.0.0.1:1234", []string{" , "}, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/track", nil)
+		r.RemoteAddr = tt.peer
+		for _, h := range tt.header {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
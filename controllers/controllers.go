@@ -1,16 +1,24 @@
 package controllers
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gogol_analytics/database"
 	"gogol_analytics/models"
+	"gogol_analytics/urlnorm"
 	"html/template"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -56,12 +64,36 @@ func (b *Broker) Broadcast(event models.Event) {
 
 // --- Helpers ---
 
+// templateFuncs are available to every view
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	// dict builds a map from key/value pairs to pass several values to a sub-template
+	"dict": func(pairs ...any) (map[string]any, error) {
+		if len(pairs)%2 != 0 {
+			return nil, fmt.Errorf("dict: odd number of arguments")
+		}
+		m := make(map[string]any, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			key, ok := pairs[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+			}
+			m[key] = pairs[i+1]
+		}
+		return m, nil
+	},
+	// formatFloat renders a number with a fixed number of decimals, e.g. formatFloat 0.1234 3 -> "0.123"
+	"formatFloat": func(v float64, digits int) string {
+		return strconv.FormatFloat(v, 'f', digits, 64)
+	},
+}
+
 func parseTemplates(templates ...string) (*template.Template, error) {
 	var paths []string
 	for _, t := range templates {
 		paths = append(paths, filepath.Join("views", t))
 	}
-	return template.ParseFiles(paths...)
+	return template.New(filepath.Base(paths[0])).Funcs(templateFuncs).ParseFiles(paths...)
 }
 
 func parseUA(ua string) (os, browser, device string) {
@@ -128,19 +160,87 @@ func parseKeyword(referrer string) string {
 	return ""
 }
 
-func extractPath(urlStr string) string {
-	if urlStr == "" {
-		return "/"
+// parseLanguage splits a BCP 47 tag such as "en-us" or "zh_Hant_TW" into its primary
+// language ("en", "zh") and a canonically cased locale ("en-US", "zh-Hant-TW").
+// Variants and extensions are dropped; invalid tags return empty strings.
+func parseLanguage(tag string) (language, locale string) {
+	tag = strings.TrimSpace(strings.ReplaceAll(tag, "_", "-"))
+	subtags := strings.Split(tag, "-")
+
+	primary := strings.ToLower(subtags[0])
+	if len(primary) < 2 || len(primary) > 3 || !isAlpha(primary) {
+		return "", ""
 	}
-	u, err := url.Parse(urlStr)
-	if err != nil {
-		return urlStr // Return original if can't parse
+	parts := []string{primary}
+	for _, sub := range subtags[1:] {
+		switch {
+		case len(sub) == 4 && isAlpha(sub) && len(parts) == 1:
+			// Script, e.g. Hant
+			parts = append(parts, strings.ToUpper(sub[:1])+strings.ToLower(sub[1:]))
+		case len(sub) == 2 && isAlpha(sub), len(sub) == 3 && strings.Trim(sub, "0123456789") == "":
+			// Region, e.g. US or 419
+			parts = append(parts, strings.ToUpper(sub))
+			return primary, strings.Join(parts, "-")
+		default:
+			return primary, strings.Join(parts, "-")
+		}
+	}
+	return primary, strings.Join(parts, "-")
+}
+
+func isAlpha(s string) bool {
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// preferredLanguage returns the tag with the highest quality value of an
+// Accept-Language header, e.g. "fr-CH" for "fr-CH, fr;q=0.9, en;q=0.8"
+func preferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, entry := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
 	}
-	path := u.Path
-	if path == "" {
-		return "/"
+	return best
+}
+
+// visitorLanguage falls back to the Accept-Language header when the tracker did not
+// send navigator.language; EnrichEvent then splits the tag into language and locale
+func visitorLanguage(event *models.Event, r *http.Request) {
+	if event.Language == "" {
+		event.Language = preferredLanguage(r.Header.Get("Accept-Language"))
 	}
-	return path
+}
+
+// languageNames labels the most common primary languages in reports
+var languageNames = map[string]string{
+	"ar": "Arabic", "bg": "Bulgarian", "bn": "Bengali", "ca": "Catalan", "cs": "Czech",
+	"da": "Danish", "de": "German", "el": "Greek", "en": "English", "es": "Spanish",
+	"et": "Estonian", "fa": "Persian", "fi": "Finnish", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "hr": "Croatian", "hu": "Hungarian", "id": "Indonesian", "it": "Italian",
+	"ja": "Japanese", "ko": "Korean", "lt": "Lithuanian", "lv": "Latvian", "ms": "Malay",
+	"nb": "Norwegian Bokmål", "nl": "Dutch", "no": "Norwegian", "pl": "Polish", "pt": "Portuguese",
+	"ro": "Romanian", "ru": "Russian", "sk": "Slovak", "sl": "Slovenian", "sr": "Serbian",
+	"sv": "Swedish", "th": "Thai", "tr": "Turkish", "uk": "Ukrainian", "vi": "Vietnamese",
+	"zh": "Chinese",
 }
 
 func extractTLD(urlStr string) string {
@@ -162,61 +262,314 @@ func extractTLD(urlStr string) string {
 	return host
 }
 
-// isAuthorizedDomain checks if the given URL's domain is in the authorized websites list
-func isAuthorizedDomain(urlStr string) bool {
-	if urlStr == "" {
+// publicBaseURL returns the URL under which this server is reachable by tracked websites.
+// The Settings value wins, then the GOGOL_PUBLIC_URL environment variable, then the request host.
+func publicBaseURL(r *http.Request) string {
+	base, err := database.GetSetting("public_url")
+	if err != nil {
+		fmt.Printf("Error reading public URL setting: %v\n", err)
+	}
+	if base == "" {
+		base = os.Getenv("GOGOL_PUBLIC_URL")
+	}
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// normalizeBaseURL validates a user supplied base URL and strips the trailing slash
+func normalizeBaseURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid public URL %q", raw)
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// trustedProxies lists the peers allowed to report the client address in X-Forwarded-For.
+// Set GOGOL_TRUSTED_PROXIES to a comma separated list of IPs or CIDRs (default: loopback only).
+var trustedProxies = parseTrustedProxies(os.Getenv("GOGOL_TRUSTED_PROXIES"))
+
+func parseTrustedProxies(list string) []*net.IPNet {
+	if list == "" {
+		list = "127.0.0.0/8,::1/128"
+	}
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			fmt.Printf("Ignoring invalid trusted proxy %q: %v\n", entry, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
 		return false
 	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
 
-	// Parse the URL to extract the domain
-	u, err := url.Parse(urlStr)
+// clientIP returns the address of the visitor, walking X-Forwarded-For from the right
+// for as long as the hops are trusted proxies (e.g. a first-party proxy on the customer's site)
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return false
+		ip = r.RemoteAddr
 	}
 
-	// Get the host (domain)
-	host := u.Host
-	if host == "" {
-		// Try to parse as just a domain
-		host = u.Path
+	if !isTrustedProxy(ip) {
+		return ip
 	}
 
-	// Remove port if present
-	if idx := strings.Index(host, ":"); idx != -1 {
-		host = host[:idx]
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// isAuthorizedDomain checks if the given URL's domain is in the authorized websites list
+func isAuthorizedDomain(urlStr string) bool {
+	_, ok := findWebsite(urlStr)
+	return ok
+}
+
+// findWebsite returns the registered website whose domain matches the given URL
+func findWebsite(urlStr string) (models.Website, bool) {
+	if urlStr == "" {
+		return models.Website{}, false
 	}
 
 	// Get all authorized websites from database
 	websites, err := database.GetWebsites()
 	if err != nil {
 		fmt.Printf("Error checking authorized domains: %v\n", err)
-		return false
+		return models.Website{}, false
 	}
+	return MatchWebsite(websites, urlStr)
+}
 
-	// Check if the domain matches any authorized website
+// MatchWebsite returns the website whose domain matches the URL's domain
+func MatchWebsite(websites []models.Website, urlStr string) (models.Website, bool) {
 	for _, website := range websites {
-		websiteURL, err := url.Parse(website.URL)
-		if err != nil {
-			continue
+		if belongsToWebsite(urlStr, website) {
+			return website, true
 		}
+	}
+	return models.Website{}, false
+}
 
-		websiteHost := websiteURL.Host
-		if websiteHost == "" {
-			websiteHost = websiteURL.Path
-		}
+// belongsToWebsite reports whether the URL's domain is the website's domain (case-insensitive, port ignored)
+func belongsToWebsite(urlStr string, website models.Website) bool {
+	if _, err := url.Parse(urlStr); err != nil {
+		return false
+	}
+	if _, err := url.Parse(website.URL); err != nil {
+		return false
+	}
+	host := extractTLD(urlStr)
+	return host != "" && strings.EqualFold(host, extractTLD(website.URL))
+}
+
+// normalizeURLs strips the website's unwanted query parameters from the page URL and
+// from referrers on the same site, records the normalized path used to group page
+// views and splits both URLs into the host, path and query columns
+func normalizeURLs(event *models.Event, rules models.NormalizationRules) {
+	event.CurrentURL = urlnorm.StripParams(event.CurrentURL, rules)
+	event.Referrer = urlnorm.StripReferrerParams(event.Referrer, event.CurrentURL, rules)
+	event.NormalizedPath = urlnorm.NormalizedPath(event.CurrentURL, rules)
+	event.Hostname, event.Path, event.QueryString = urlnorm.Split(event.CurrentURL)
+	event.ReferrerHost, event.ReferrerPath, _ = urlnorm.Split(event.Referrer)
+}
+
+// 1x1 transparent GIF (43 bytes)
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00,
+	0x01, 0x00, 0x80, 0x00, 0x00, 0xFF, 0xFF, 0xFF,
+	0x00, 0x00, 0x00, 0x21, 0xF9, 0x04, 0x01, 0x00,
+	0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44,
+	0x01, 0x00, 0x3B,
+}
+
+func writePixel(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Write(transparentGIF)
+}
 
-		// Remove port if present
-		if idx := strings.Index(websiteHost, ":"); idx != -1 {
-			websiteHost = websiteHost[:idx]
+// trackPayload is the body sent by tracker.js; IP is only used for hashing, never stored
+type trackPayload struct {
+	models.Event
+	IP string `json:"ip"`
+}
+
+// isValidClientID accepts the random IDs generated by tracker.js (UUIDs or hex strings)
+func isValidClientID(id string) bool {
+	if len(id) < 16 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
 		}
+	}
+	return true
+}
 
-		// Match domain (case-insensitive)
-		if strings.EqualFold(host, websiteHost) {
-			return true
+// maxTrackBody bounds the size of a tracking request body
+const maxTrackBody = 64 << 10
+
+// decodeTrackPayload reads a tracking payload from any body a browser can send without a
+// CORS preflight: JSON as application/json or text/plain (navigator.sendBeacon with a string
+// or Blob), or application/x-www-form-urlencoded / multipart fields (sendBeacon with
+// URLSearchParams or FormData), either as a single JSON "data" field or one field per property.
+func decodeTrackPayload(w http.ResponseWriter, r *http.Request) (trackPayload, error) {
+	var payload trackPayload
+	r.Body = http.MaxBytesReader(w, r.Body, maxTrackBody)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseMultipartForm(maxTrackBody); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return payload, err
 		}
+		if data := r.PostFormValue("data"); data != "" {
+			err := json.Unmarshal([]byte(data), &payload)
+			return payload, err
+		}
+		payload.CurrentURL = r.PostFormValue("current_url")
+		payload.Referrer = r.PostFormValue("referrer")
+		payload.UserAgent = r.PostFormValue("user_agent")
+		payload.ScreenResolution = r.PostFormValue("screen_resolution")
+		payload.Country = r.PostFormValue("country")
+		payload.CountryCode = r.PostFormValue("country_code")
+		payload.PageTitle = r.PostFormValue("page_title")
+		payload.Language = r.PostFormValue("language")
+		payload.IsBot = r.PostFormValue("is_bot") == "true"
+		payload.IP = r.PostFormValue("ip")
+		payload.VisitorID = r.PostFormValue("visitor_id")
+		return payload, nil
+	default:
+		// application/json, text/plain and untyped bodies all carry JSON
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		return payload, err
 	}
+}
 
-	return false
+// vitalsPayload is the Core Web Vitals beacon sent by tracker.js when the page is hidden
+type vitalsPayload struct {
+	models.WebVitals
+	CurrentURL string `json:"current_url"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// clampVital discards values no browser reports for a real page view
+func clampVital(v *float64, max float64) *float64 {
+	if v == nil || *v < 0 || *v > max || math.IsNaN(*v) {
+		return nil
+	}
+	return v
+}
+
+// errorPayload is a JavaScript error reported by tracker.js (opt-in with data-errors="true")
+type errorPayload struct {
+	models.JSError
+	CurrentURL string `json:"current_url"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// Limits applied to reported errors so a noisy page cannot bloat the database
+const (
+	maxErrorMessage = 1000
+	maxErrorStack   = 8000
+)
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// errorFingerprint groups occurrences of the same error: identical message thrown
+// from the same script location, whatever the page or the script's query string
+func errorFingerprint(e models.JSError) string {
+	source := e.Source
+	if i := strings.IndexAny(source, "?#"); i >= 0 {
+		source = source[:i]
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d", e.Message, source, e.Line, e.Column)))
+	return hex.EncodeToString(hash[:8])
+}
+
+// EnrichEvent fills the fields every ingestion path derives on the server for a page
+// view of the website: normalized URL parts, OS/browser/device, search keyword,
+// language and locale (from the raw tag in Language), the privacy-preserving IP hash
+// and the visitor ID. The caller sets the timestamp, source type and bot flag.
+func EnrichEvent(event *models.Event, website models.Website, ip string) {
+	event.WebsiteID = website.ID
+	normalizeURLs(event, website.Normalization)
+
+	event.OS, event.Browser, event.Device = parseUA(event.UserAgent)
+	event.Keyword = parseKeyword(event.Referrer)
+	event.Language, event.Locale = parseLanguage(event.Language)
+
+	// Hash IP with UserAgent as salt for privacy
+	// This creates a unique identifier while not storing the actual IP
+	hash := sha256.Sum256([]byte(ip + event.UserAgent))
+	event.IPHash = hex.EncodeToString(hash[:])
+
+	// Sites that opted into cookie identity keep the client's first-party ID;
+	// everyone else is identified by the same hash (IP + UserAgent)
+	if website.IdentityMode != models.IdentityCookie || !isValidClientID(event.VisitorID) {
+		event.VisitorID = event.IPHash
+	}
 }
 
 // --- Handlers ---
@@ -237,40 +590,44 @@ func Track(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Temporary struct to receive IP from client
-	var payload struct {
-		models.Event
-		IP string `json:"ip"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+	payload, err := decodeTrackPayload(w, r)
+	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
 
 	event := payload.Event
+	if event.UserAgent == "" {
+		event.UserAgent = r.UserAgent()
+	}
+
+	// The tracker reports the IP seen by its geolocation lookup; fall back to the
+	// connection (or first-party proxy) address when it could not
+	if payload.IP == "" {
+		payload.IP = clientIP(r)
+	}
 
 	// Validate domain - reject events from unauthorized domains
-	if !isAuthorizedDomain(event.CurrentURL) {
+	website, ok := findWebsite(event.CurrentURL)
+	if !ok {
 		http.Error(w, "Unauthorized domain", http.StatusForbidden)
 		return
 	}
+	event.SourceType = models.SourceJS
 
 	// Fill missing server-side fields
 	event.Timestamp = time.Now()
-	event.OS, event.Browser, event.Device = parseUA(event.UserAgent)
-	event.Keyword = parseKeyword(event.Referrer)
-
-	// Hash IP with UserAgent as salt for privacy
-	// This creates a unique identifier while not storing the actual IP
-	hash := sha256.Sum256([]byte(payload.IP + event.UserAgent))
-	event.IPHash = hex.EncodeToString(hash[:])
-
-	// Use the same hash for VisitorID (IP + UserAgent uniquely identifies a visitor)
-	event.VisitorID = event.IPHash
-
-	// Save to DB
-	if err := database.InsertEvent(event); err != nil {
+	visitorLanguage(&event, r)
+	EnrichEvent(&event, website, payload.IP)
+
+	// Queue for the background writer
+	if err := database.EnqueueEvent(event); err != nil {
+		if errors.Is(err, database.ErrQueueFull) {
+			// Ask the tracker to back off instead of piling more writes onto SQLite
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service busy", http.StatusServiceUnavailable)
+			return
+		}
 		fmt.Printf("DB Error: %v\n", err)
 		// Don't fail the request, just log
 	}
@@ -281,7 +638,137 @@ func Track(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// TrackNoscript handles tracking for users without JavaScript via image pixel
+// Vitals receives Core Web Vitals measured by tracker.js for a page view
+func Vitals(w http.ResponseWriter, r *http.Request) {
+	// CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload vitalsPayload
+	r.Body = http.MaxBytesReader(w, r.Body, maxTrackBody)
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Validate domain - reject samples from unauthorized domains
+	website, ok := findWebsite(payload.CurrentURL)
+	if !ok {
+		http.Error(w, "Unauthorized domain", http.StatusForbidden)
+		return
+	}
+
+	if payload.UserAgent == "" {
+		payload.UserAgent = r.UserAgent()
+	}
+
+	vitals := payload.WebVitals
+	vitals.WebsiteID = website.ID
+	vitals.Timestamp = time.Now()
+	vitals.Path = urlnorm.NormalizedPath(urlnorm.StripParams(payload.CurrentURL, website.Normalization), website.Normalization)
+	_, vitals.Browser, vitals.Device = parseUA(payload.UserAgent)
+	vitals.LCP = clampVital(vitals.LCP, 120000)
+	vitals.CLS = clampVital(vitals.CLS, 10)
+	vitals.INP = clampVital(vitals.INP, 60000)
+	vitals.FCP = clampVital(vitals.FCP, 120000)
+	vitals.TTFB = clampVital(vitals.TTFB, 120000)
+
+	if vitals.LCP == nil && vitals.CLS == nil && vitals.INP == nil && vitals.FCP == nil && vitals.TTFB == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := database.EnqueueWebVitals(vitals); err != nil {
+		if errors.Is(err, database.ErrQueueFull) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service busy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Printf("DB Error (vitals): %v\n", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// JSErrors receives client-side JavaScript errors captured by tracker.js
+func JSErrors(w http.ResponseWriter, r *http.Request) {
+	// CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload errorPayload
+	r.Body = http.MaxBytesReader(w, r.Body, maxTrackBody)
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Validate domain - reject errors from unauthorized domains
+	website, ok := findWebsite(payload.CurrentURL)
+	if !ok {
+		http.Error(w, "Unauthorized domain", http.StatusForbidden)
+		return
+	}
+
+	if payload.UserAgent == "" {
+		payload.UserAgent = r.UserAgent()
+	}
+
+	jsErr := payload.JSError
+	jsErr.Message = truncate(strings.TrimSpace(jsErr.Message), maxErrorMessage)
+	if jsErr.Message == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	jsErr.Source = truncate(jsErr.Source, 2048)
+	jsErr.Stack = truncate(jsErr.Stack, maxErrorStack)
+	jsErr.WebsiteID = website.ID
+	jsErr.Timestamp = time.Now()
+	jsErr.Fingerprint = errorFingerprint(jsErr)
+	jsErr.Path = urlnorm.NormalizedPath(urlnorm.StripParams(payload.CurrentURL, website.Normalization), website.Normalization)
+	jsErr.OS, jsErr.Browser, _ = parseUA(payload.UserAgent)
+
+	if err := database.EnqueueJSError(jsErr); err != nil {
+		if errors.Is(err, database.ErrQueueFull) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service busy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Printf("DB Error (js error): %v\n", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TrackNoscript handles tracking for users without JavaScript via image pixel.
+// The page can identify itself with query parameters:
+//
+//	/api/track-noscript?site=SITE_ID&url=https://example.com/page&title=Page+title&r=https://referrer
+//
+// Without "url" the page is taken from the Referer header; without "site" the
+// website is looked up from the page's domain.
 func TrackNoscript(w http.ResponseWriter, r *http.Request) {
 	// CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -297,15 +784,13 @@ func TrackNoscript(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := r.URL.Query()
+
 	// Extract server-side data
 	var event models.Event
 
-	// Get IP from request
-	ip := r.RemoteAddr
-	// Strip port if present
-	if idx := strings.LastIndex(ip, ":"); idx != -1 {
-		ip = ip[:idx]
-	}
+	// Get IP from request (honoring trusted proxies)
+	ip := clientIP(r)
 
 	// Get User-Agent from headers
 	event.UserAgent = r.Header.Get("User-Agent")
@@ -313,58 +798,64 @@ func TrackNoscript(w http.ResponseWriter, r *http.Request) {
 		event.UserAgent = "unknown"
 	}
 
-	// Get current URL from Referer header (the page making the request)
-	event.CurrentURL = r.Header.Get("Referer")
+	// Page URL from the query, else from the Referer header (the page making the request)
+	event.CurrentURL = query.Get("url")
 	if event.CurrentURL == "" {
-		event.CurrentURL = "unknown"
+		event.CurrentURL = r.Header.Get("Referer")
 	}
+	event.PageTitle = query.Get("title")
 
-	// Validate domain - reject events from unauthorized domains
-	if event.CurrentURL != "unknown" && !isAuthorizedDomain(event.CurrentURL) {
-		// Return pixel anyway but don't save the event
-		w.Header().Set("Content-Type", "image/gif")
-		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-		gif := []byte{
-			0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00,
-			0x01, 0x00, 0x80, 0x00, 0x00, 0xFF, 0xFF, 0xFF,
-			0x00, 0x00, 0x00, 0x21, 0xF9, 0x04, 0x01, 0x00,
-			0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00,
-			0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44,
-			0x01, 0x00, 0x3B,
-		}
-		w.Write(gif)
-		return
+	// Referrer of the page, passed explicitly since the Referer header holds the page itself
+	event.Referrer = query.Get("r")
+
+	// Resolve the website - the explicit site ID wins, the page's domain must still match it
+	var website models.Website
+	if siteID := query.Get("site"); siteID != "" {
+		var ok bool
+		var err error
+		website, ok, err = database.GetWebsite(siteID)
+		if err != nil {
+			fmt.Printf("DB Error (noscript): %v\n", err)
+		}
+		if !ok || (event.CurrentURL != "" && !belongsToWebsite(event.CurrentURL, website)) {
+			// Return pixel anyway but don't save the event
+			writePixel(w)
+			return
+		}
+	} else if event.CurrentURL != "" {
+		// Validate domain - reject events from unauthorized domains
+		var ok bool
+		website, ok = findWebsite(event.CurrentURL)
+		if !ok {
+			// Return pixel anyway but don't save the event
+			writePixel(w)
+			return
+		}
 	}
 
-	// Get referrer from Referer header or query param
-	event.Referrer = r.URL.Query().Get("r")
-	if event.Referrer == "" {
-		// Try to get from a different header or leave empty
-		event.Referrer = ""
+	if event.CurrentURL == "" {
+		event.CurrentURL = "unknown"
 	}
 
 	// Set timestamp
 	event.Timestamp = time.Now()
 
-	// Parse User-Agent for OS, Browser, Device
-	event.OS, event.Browser, event.Device = parseUA(event.UserAgent)
-	event.Keyword = parseKeyword(event.Referrer)
+	// Parse User-Agent, referrer and language; visitors are identified by the IP hash
+	visitorLanguage(&event, r)
+	EnrichEvent(&event, website, ip)
 
 	// Set default values for fields that can't be obtained without JavaScript
 	event.Country = "unknown"
 	event.CountryCode = "unknown"
 	event.ScreenResolution = "unknown"
 
-	// Mark as bot (noscript users)
-	event.IsBot = true
+	// Noscript visitors are people too; only self-declared crawlers count as bots
+	event.SourceType = models.SourceNoscript
+	event.IsBot = models.IsBotUserAgent(event.UserAgent)
 
-	// Hash IP with UserAgent as salt for privacy
-	hash := sha256.Sum256([]byte(ip + event.UserAgent))
-	event.IPHash = hex.EncodeToString(hash[:])
-	event.VisitorID = event.IPHash
-
-	// Save to DB
-	if err := database.InsertEvent(event); err != nil {
+	// Queue for the background writer; a full queue drops the hit since
+	// images cannot honor Retry-After
+	if err := database.EnqueueEvent(event); err != nil {
 		fmt.Printf("DB Error (noscript): %v\n", err)
 		// Don't fail the request, just log
 	}
@@ -373,87 +864,239 @@ func TrackNoscript(w http.ResponseWriter, r *http.Request) {
 	sseBroker.Broadcast(event)
 
 	// Return 1x1 transparent GIF
-	w.Header().Set("Content-Type", "image/gif")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Expires", "0")
+	writePixel(w)
+}
 
-	// 1x1 transparent GIF (43 bytes)
-	gif := []byte{
-		0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00,
-		0x01, 0x00, 0x80, 0x00, 0x00, 0xFF, 0xFF, 0xFF,
-		0x00, 0x00, 0x00, 0x21, 0xF9, 0x04, 0x01, 0x00,
-		0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00,
-		0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44,
-		0x01, 0x00, 0x3B,
+// comparisonLimit bounds the rows read from the comparison period to find the
+// previous value of each row shown
+const comparisonLimit = 1000
+
+// viewerLocation returns the time zone reports are shown in: the viewer's tz override,
+// else the website's (see database.ReportLocation)
+func viewerLocation(tz, websiteID string) (*time.Location, error) {
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", tz)
+		}
+		return loc, nil
 	}
-	w.Write(gif)
+	return database.ReportLocation(websiteID)
 }
 
 func Traffic(w http.ResponseWriter, r *http.Request) {
-	timeRange := r.URL.Query().Get("range")
+	params := r.URL.Query()
+
+	// One website, or every website by default
+	websites, err := database.GetWebsites()
+	if err != nil {
+		fmt.Printf("Error getting websites: %v\n", err)
+	}
+	site := params.Get("site")
+	if site != "" {
+		if _, ok, err := database.GetWebsite(site); err != nil || !ok {
+			fmt.Printf("Ignoring unknown site %q\n", site)
+			site = ""
+		}
+	}
+
+	// Days and chart buckets follow the website's time zone (the websites' when they
+	// share one) unless the viewer picks one
+	timeZone := params.Get("tz")
+	loc, err := viewerLocation(timeZone, site)
+	if err != nil {
+		fmt.Printf("Ignoring time zone: %v\n", err)
+		timeZone = ""
+		if loc, err = database.ReportLocation(site); err != nil {
+			fmt.Printf("Error getting time zone: %v\n", err)
+		}
+	}
+
+	timeRange := params.Get("range")
 	if timeRange == "" {
 		timeRange = "24h"
 	}
+	q, interval, err := database.ResolveRange(timeRange, params.Get("from"), params.Get("to"), loc)
+	if err != nil {
+		fmt.Printf("Invalid range: %v\n", err)
+		timeRange = "24h"
+		q, interval = database.RangeQuery(timeRange, loc)
+	}
 
-	chartData, err := database.GetChartData(timeRange)
+	// Filters from the URL apply to every query of the page
+	var filters []models.Filter
+	language := ""
+	for _, raw := range params["filter"] {
+		filter, err := database.ParseFilter(raw)
+		if err != nil {
+			fmt.Printf("Ignoring filter: %v\n", err)
+			continue
+		}
+		filters = append(filters, filter)
+		if filter.Dimension == "language" && filter.Op == models.FilterEquals {
+			language = filter.Value
+		}
+	}
+	q.WebsiteID = site
+	q.Filters = filters
+	q = q.Plan()
+
+	// Optional comparison with the previous period or the same period last year
+	compare := params.Get("compare")
+	var previous database.Query
+	if compare != "" && timeRange != "all" {
+		if previous, err = q.Compare(compare); err != nil {
+			fmt.Printf("Ignoring comparison: %v\n", err)
+			compare = ""
+		}
+	} else {
+		compare = ""
+	}
+
+	chartData, err := database.GetChartData(q, interval)
 	if err != nil {
 		fmt.Printf("Error getting chart data: %v\n", err)
 	}
 
-	// Helper to get stats safely
-	getStats := func(col string) []models.TableRow {
-		s, err := database.GetTopStats(col, 10)
+	// Visitors are counted over the whole range: bucket counts add up a visitor once per bucket
+	totalViews := models.TableRow{Key: "Views"}
+	totalVisitors := models.TableRow{Key: "Visitors"}
+	for _, point := range chartData {
+		totalViews.Value += point.Views
+	}
+	if totalVisitors.Value, err = database.CountVisitors(q); err != nil {
+		fmt.Printf("Error counting visitors: %v\n", err)
+	}
+	var previousViews []int
+	if compare != "" {
+		previousData, err := database.GetChartData(previous, interval)
+		if err != nil {
+			fmt.Printf("Error getting comparison chart data: %v\n", err)
+		}
+		// Buckets are matched by position; a year earlier may have one day more or less
+		previousViews = make([]int, len(chartData))
+		for i, point := range previousData {
+			if i < len(previousViews) {
+				previousViews[i] = point.Views
+			}
+			totalViews.Previous += point.Views
+		}
+		if totalVisitors.Previous, err = database.CountVisitors(previous); err != nil {
+			fmt.Printf("Error counting comparison visitors: %v\n", err)
+		}
+	}
+
+	// Helper to get stats safely, with their value in the comparison period
+	getStats := func(dimension string) []models.TableRow {
+		s, err := database.GetTopStats(q, dimension, 10)
 		if err != nil {
-			fmt.Printf("Error getting stats for %s: %v\n", col, err)
+			fmt.Printf("Error getting stats for %s: %v\n", dimension, err)
 			return []models.TableRow{}
 		}
+		if compare != "" {
+			before, err := database.GetTopStats(previous, dimension, comparisonLimit)
+			if err != nil {
+				fmt.Printf("Error getting comparison stats for %s: %v\n", dimension, err)
+			}
+			counts := make(map[string]int, len(before))
+			for _, row := range before {
+				counts[row.Key] = row.Value
+			}
+			for i := range s {
+				s[i].Previous = counts[s[i].Key]
+			}
+		}
 		return s
 	}
 
 	// Get recent events
-	recentEvents, err := database.GetRecentEvents(20)
+	recentEvents, err := database.GetRecentEvents(q, 20)
 	if err != nil {
 		fmt.Printf("Error getting recent events: %v\n", err)
 	}
 
-	// Get source stats specifically to handle "Direct"
-	sourceStats, err := database.GetTopSources(10)
-	if err != nil {
-		fmt.Printf("Error getting source stats: %v\n", err)
+	// Landing and exit pages of the sessions in the selected range
+	getPageFlow := func(get func(database.Query, int) ([]models.PageFlowRow, error), name string) []models.PageFlowRow {
+		rows, err := get(q, 10)
+		if err != nil {
+			fmt.Printf("Error getting %s pages: %v\n", name, err)
+			return nil
+		}
+		if compare != "" {
+			before, err := get(previous, comparisonLimit)
+			if err != nil {
+				fmt.Printf("Error getting comparison %s pages: %v\n", name, err)
+			}
+			sessions := make(map[string]int, len(before))
+			for _, row := range before {
+				sessions[row.Key] = row.Sessions
+			}
+			for i := range rows {
+				rows[i].PreviousSessions = sessions[rows[i].Key]
+			}
+		}
+		return rows
 	}
 
-	// Process page stats to show only path
-	pageStats := getStats("current_url")
-	for i := range pageStats {
-		pageStats[i].Key = extractPath(pageStats[i].Key)
+	// Languages, or the locales of the language the page is filtered on
+	var languageStats []models.TableRow
+	if language != "" {
+		languageStats = getStats("locale")
+	} else {
+		languageStats = getStats("language")
+		for i := range languageStats {
+			languageStats[i].Title = languageNames[languageStats[i].Key]
+		}
 	}
 
-	// Process source stats to show only TLD
-	for i := range sourceStats {
-		sourceStats[i].Key = extractTLD(sourceStats[i].Key)
+	hasImported := false
+	for _, point := range chartData {
+		hasImported = hasImported || point.ImportedVisitors > 0
 	}
 
-	// Process referring sites to show only TLD
-	referringSitesStats := getStats("referrer")
-	for i := range referringSitesStats {
-		referringSitesStats[i].Key = extractTLD(referringSitesStats[i].Key)
+	lastDay := q.To.Add(-time.Nanosecond)
+	var rangeLabel string
+	for _, preset := range models.TrafficRanges {
+		if preset.Key == timeRange {
+			rangeLabel = preset.Title
+		}
+	}
+	if timeRange == "custom" {
+		rangeLabel = q.From.Format("2 Jan 2006") + " – " + lastDay.Format("2 Jan 2006")
 	}
 
 	data := models.TrafficPageData{
 		CurrentPage:         "traffic",
 		TimeRange:           timeRange,
+		From:                q.From.Format("2006-01-02"),
+		To:                  lastDay.Format("2006-01-02"),
+		RangeLabel:          rangeLabel,
+		Compare:             compare,
 		ChartData:           chartData,
-		PageStats:           pageStats,
+		PreviousViews:       previousViews,
+		TotalViews:          totalViews,
+		TotalVisitors:       totalVisitors,
+		HasImported:         hasImported,
+		PageStats:           getStats("page"),
+		EntryPageStats:      getPageFlow(database.GetEntryPages, "entry"),
+		ExitPageStats:       getPageFlow(database.GetExitPages, "exit"),
 		CountryStats:        getStats("country"),
 		DeviceStats:         getStats("device"),
 		OSStats:             getStats("os"),
-		SourceStats:         sourceStats,
-		ReferringSitesStats: referringSitesStats,
+		SourceStats:         getStats("source"),
+		ReferringSitesStats: getStats("referrer"),
 		BrowserStats:        getStats("browser"),
-		ResolutionStats:     getStats("screen_resolution"),
+		ResolutionStats:     getStats("screen"),
 		KeywordStats:        getStats("keyword"),
+		SourceTypeStats:     getStats("source_type"),
+		LanguageStats:       languageStats,
+		Language:            language,
 		RecentEvents:        recentEvents,
+		Filters:             filters,
+		TimeZone:            timeZone,
+		Location:            loc.String(),
+		Site:                site,
+		Websites:            websites,
 	}
 
 	tmpl, err := parseTemplates("layout.html", "traffic.html")
@@ -464,14 +1107,103 @@ func Traffic(w http.ResponseWriter, r *http.Request) {
 	tmpl.ExecuteTemplate(w, "layout", data)
 }
 
+// Performance shows Core Web Vitals percentiles and ratings per page, device and browser
+func Performance(w http.ResponseWriter, r *http.Request) {
+	timeRange := r.URL.Query().Get("range")
+	if timeRange == "" {
+		timeRange = "24h"
+	}
+
+	metric := models.VitalMetrics[0]
+	for _, m := range models.VitalMetrics {
+		if m.Name == r.URL.Query().Get("metric") {
+			metric = m
+		}
+	}
+
+	overview, err := database.GetVitalsOverview(timeRange)
+	if err != nil {
+		fmt.Printf("Error getting vitals overview: %v\n", err)
+	}
+
+	// Helper to get a breakdown safely
+	getBreakdown := func(dimension string) []models.VitalsRow {
+		rows, err := database.GetVitalsBreakdown(timeRange, metric.Name, dimension, 10)
+		if err != nil {
+			fmt.Printf("Error getting vitals by %s: %v\n", dimension, err)
+			return []models.VitalsRow{}
+		}
+		return rows
+	}
+
+	data := models.PerformancePageData{
+		CurrentPage: "performance",
+		TimeRange:   timeRange,
+		Metric:      metric,
+		Overview:    overview,
+		ByPage:      getBreakdown("path"),
+		ByDevice:    getBreakdown("device"),
+		ByBrowser:   getBreakdown("browser"),
+	}
+
+	tmpl, err := parseTemplates("layout.html", "performance.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+// Errors lists client-side JavaScript errors grouped by fingerprint
+func Errors(w http.ResponseWriter, r *http.Request) {
+	timeRange := r.URL.Query().Get("range")
+	if timeRange == "" {
+		timeRange = "24h"
+	}
+
+	groups, err := database.GetErrorGroups(timeRange, 50)
+	if err != nil {
+		fmt.Printf("Error getting JS errors: %v\n", err)
+		groups = []models.ErrorGroup{}
+	}
+
+	data := models.ErrorsPageData{
+		CurrentPage: "errors",
+		TimeRange:   timeRange,
+		Errors:      groups,
+	}
+
+	tmpl, err := parseTemplates("layout.html", "errors.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+// Conversions lists the custom events reported through the server-side tracking API
 func Conversions(w http.ResponseWriter, r *http.Request) {
+	timeRange := r.URL.Query().Get("range")
+	if timeRange == "" {
+		timeRange = "24h"
+	}
+
+	customEvents, err := database.GetTopCustomEvents(timeRange, 20)
+	if err != nil {
+		fmt.Printf("Error getting custom events: %v\n", err)
+	}
+
 	tmpl, err := parseTemplates("layout.html", "conversions.html")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	// Anonymous struct for now
-	data := struct{ CurrentPage string }{CurrentPage: "conversions"}
+	data := struct {
+		CurrentPage  string
+		TimeRange    string
+		CustomEvents []models.TableRow
+	}{CurrentPage: "conversions", TimeRange: timeRange, CustomEvents: customEvents}
 	tmpl.ExecuteTemplate(w, "layout", data)
 }
 
@@ -482,9 +1214,18 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	publicURL, err := database.GetSetting("public_url")
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	baseURL := publicBaseURL(r)
 	data := models.SettingsPageData{
 		CurrentPage: "settings",
-		ScriptURL:   "<script src=\"http://localhost:8091/static/js/tracker.js\"></script>",
+		BaseURL:     baseURL,
+		PublicURL:   publicURL,
+		ScriptURL:   fmt.Sprintf("<script src=\"%s/static/js/tracker.js\" data-api=\"%s/api\"></script>", baseURL, baseURL),
 		Websites:    websites,
 	}
 
@@ -519,6 +1260,128 @@ func SettingsAdd(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/settings", http.StatusSeeOther)
 }
 
+// SettingsPublicURL saves the public base URL used to build the tracking snippets
+func SettingsPublicURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		publicURL, err := normalizeBaseURL(r.FormValue("public_url"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := database.SetSetting("public_url", publicURL); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// SettingsIdentity switches a website between IP hash and cookie visitor identity
+func SettingsIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		mode := r.FormValue("mode")
+		if mode != models.IdentityHash && mode != models.IdentityCookie {
+			http.Error(w, "Invalid identity mode", http.StatusBadRequest)
+			return
+		}
+		if err := database.SetWebsiteIdentityMode(r.FormValue("id"), mode); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// SettingsTimeZone sets the time zone of a website's reports
+func SettingsTimeZone(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		timeZone := strings.TrimSpace(r.FormValue("timezone"))
+		if timeZone != "" {
+			if _, err := time.LoadLocation(timeZone); err != nil {
+				http.Error(w, "Unknown time zone", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := database.SetWebsiteTimeZone(r.FormValue("id"), timeZone); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// newAPIKey returns a random key for the server-side tracking API
+func newAPIKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "gogol_" + hex.EncodeToString(b), nil
+}
+
+// SettingsAPIKey generates (or, with revoke set, removes) the server-side tracking key of a website
+func SettingsAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		key := ""
+		if r.FormValue("revoke") == "" {
+			var err error
+			if key, err = newAPIKey(); err != nil {
+				http.Error(w, "Could not generate key", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := database.SetWebsiteAPIKey(r.FormValue("id"), key); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// SettingsURLRules saves the URL normalization rules of a website
+func SettingsURLRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		rules := models.NormalizationRules{
+			StripParams:       urlnorm.ParseList(r.FormValue("strip_params")),
+			StripAllParams:    r.FormValue("strip_all_params") == "on",
+			AllowParams:       urlnorm.ParseList(r.FormValue("allow_params")),
+			FoldTrailingSlash: r.FormValue("fold_trailing_slash") == "on",
+			FoldCase:          r.FormValue("fold_case") == "on",
+			HashRoutes:        r.FormValue("hash_routes") == "on",
+		}
+		if err := database.SetWebsiteNormalization(r.FormValue("id"), rules); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
 func SettingsDelete(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" { // Prefer POST for state changing actions
 		if err := r.ParseForm(); err != nil {
@@ -579,3 +1442,15 @@ func Events(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// Health reports the ingestion queue state as JSON
+func Health(w http.ResponseWriter, r *http.Request) {
+	stats := database.GetQueueStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if stats.Running && stats.Depth >= stats.Capacity {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(stats)
+}
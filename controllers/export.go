@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"fmt"
+	"gogol_analytics/database"
+	"gogol_analytics/export"
+	"net/http"
+	"slices"
+)
+
+// ExportEvents streams a website's raw events as CSV, NDJSON or Parquet.
+// Query parameters: site (required), format (csv, ndjson or parquet; default csv),
+// from and to (inclusive YYYY-MM-DD) and columns (comma-separated).
+func ExportEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	website, ok, err := database.GetWebsite(query.Get("site"))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Unknown website", http.StatusNotFound)
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if !slices.Contains(export.Formats, format) {
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+		return
+	}
+	from, to, err := export.ParseRange(query.Get("from"), query.Get("to"), website.Location())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns, err := export.Columns(query.Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filename := "gogol-" + website.ID
+	if query.Get("from") != "" {
+		filename += "-" + query.Get("from")
+	}
+	if query.Get("to") != "" {
+		filename += "-" + query.Get("to")
+	}
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+
+	// Headers are already sent, a failure can only cut the download short
+	if err := export.Events(w, format, website.ID, from, to, columns); err != nil {
+		fmt.Printf("Export of %s failed: %v\n", website.ID, err)
+	}
+}
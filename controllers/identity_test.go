@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"gogol_analytics/models"
+	"strings"
+	"testing"
+)
+
+func TestIsValidClientID(t *testing.T) {
+	tests := map[string]bool{
+		"0f8c2e1a-3b4d-4e5f-8a9b-0c1d2e3f4a5b": true,
+		"9f86d081884c7d65":                     true,
+		strings.Repeat("a", 64):                true,
+		"":                                     false,
+		"too-short":                            false,
+		strings.Repeat("a", 65):                false,
+		"0f8c2e1a 3b4d 4e5f 8a9b":              false,
+		"0f8c2e1a-3b4d-4e5f-8a9b-<script>":     false,
+	}
+	for id, want := range tests {
+		if got := isValidClientID(id); got != want {
+			t.Errorf("isValidClientID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestEnrichEventIdentity(t *testing.T) {
+	const clientID = "0f8c2e1a-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+	tests := []struct {
+		name     string
+		mode     string
+		clientID string
+		keep     bool
+	}{
+		{"cookie mode keeps the client ID", models.IdentityCookie, clientID, true},
+		{"hash mode ignores the client ID", models.IdentityHash, clientID, false},
+		{"default mode ignores the client ID", "", clientID, false},
+		{"cookie mode rejects a malformed ID", models.IdentityCookie, "not a valid id", false},
+		{"cookie mode without ID", models.IdentityCookie, "", false},
+	}
+	for _, tt := range tests {
+		event := models.Event{
+			CurrentURL: "https://example.com/",
+			UserAgent:  "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0",
+			VisitorID:  tt.clientID,
+		}
+		website := models.Website{ID: "site", IdentityMode: tt.mode, Normalization: models.DefaultNormalizationRules}
+		EnrichEvent(&event, website, "203.0.113.5")
+
+		if event.IPHash == "" || event.IPHash == tt.clientID {
+			t.Fatalf("%s: unexpected IP hash %q", tt.name, event.IPHash)
+		}
+		want := event.IPHash
+		if tt.keep {
+			want = tt.clientID
+		}
+		if event.VisitorID != want {
+			t.Errorf("%s: visitor_id = %q, want %q", tt.name, event.VisitorID, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import "testing"
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		tag, language, locale string
+	}{
+		{"fr", "fr", "fr"},
+		{"en-us", "en", "en-US"},
+		{"pt_BR", "pt", "pt-BR"},
+		{"zh-hant-tw", "zh", "zh-Hant-TW"},
+		{"es-419", "es", "es-419"},
+		{"de-DE-1996", "de", "de-DE"},
+		{"*", "", ""},
+		{"", "", ""},
+		{"english", "", ""},
+	}
+	for _, tt := range tests {
+		language, locale := parseLanguage(tt.tag)
+		if language != tt.language || locale != tt.locale {
+			t.Errorf("parseLanguage(%q) = %q, %q; want %q, %q", tt.tag, language, locale, tt.language, tt.locale)
+		}
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := map[string]string{
+		"fr-CH, fr;q=0.9, en;q=0.8": "fr-CH",
+		"en;q=0.5, de;q=0.9":        "de",
+		"*;q=0.5, nl":               "nl",
+		"":                          "",
+	}
+	for header, want := range tests {
+		if got := preferredLanguage(header); got != want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
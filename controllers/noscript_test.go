@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"gogol_analytics/database"
+	"gogol_analytics/models"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrackNoscriptParameters(t *testing.T) {
+	setupTestDB(t)
+	if err := database.AddWebsite("Example", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	websites, err := database.GetWebsites()
+	if err != nil || len(websites) != 1 {
+		t.Fatalf("expected one website, got %v (%v)", websites, err)
+	}
+	site := websites[0].ID
+
+	tests := []struct {
+		name    string
+		query   string
+		referer string
+		stored  bool
+	}{
+		{"site, url and title", "?site=" + site + "&url=https://example.com/pricing&title=Pricing", "", true},
+		{"page from Referer", "?site=" + site, "https://example.com/from-referer", true},
+		{"domain without site", "?url=https://example.com/no-site", "", true},
+		{"unknown site", "?site=SITE_0&url=https://example.com/unknown", "", false},
+		{"url of another domain", "?site=" + site + "&url=https://other.com/", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database.ClearAllEvents()
+			r := httptest.NewRequest("GET", "/api/track-noscript"+tt.query, nil)
+			r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			w := httptest.NewRecorder()
+			TrackNoscript(w, r)
+			if w.Code != 200 || w.Header().Get("Content-Type") != "image/gif" {
+				t.Fatalf("expected a pixel, got %d %q", w.Code, w.Header().Get("Content-Type"))
+			}
+
+			events, err := database.GetRecentEvents(database.Query{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.stored {
+				if len(events) != 0 {
+					t.Errorf("expected the hit to be dropped, got %+v", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("expected one event, got %d", len(events))
+			}
+			e := events[0]
+			if e.WebsiteID != site || e.SourceType != models.SourceNoscript || e.IsBot {
+				t.Errorf("unexpected event %+v", e)
+			}
+			if tt.name == "site, url and title" && (e.CurrentURL != "https://example.com/pricing" || e.PageTitle != "Pricing") {
+				t.Errorf("expected the page from the query, got %q %q", e.CurrentURL, e.PageTitle)
+			}
+			if tt.referer != "" && e.CurrentURL != tt.referer {
+				t.Errorf("expected the page from the Referer header, got %q", e.CurrentURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"gogol_analytics/database"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeBaseURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+		ok        bool
+	}{
+		{"", "", true},
+		{"  https://stats.example.com/  ", "https://stats.example.com", true},
+		{"http://example.com/gogol/?x=1#top", "http://example.com/gogol", true},
+		{"ftp://example.com", "", false},
+		{"stats.example.com", "", false},
+		{"https://", "", false},
+		{"https://exa mple.com", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeBaseURL(tt.raw)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("normalizeBaseURL(%q) = %q, %v; want %q (ok %v)", tt.raw, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPublicBaseURL(t *testing.T) {
+	setupTestDB(t)
+
+	r := httptest.NewRequest("GET", "/settings", nil)
+	r.Host = "gogol.local:8090"
+	t.Setenv("GOGOL_PUBLIC_URL", "")
+	if got := publicBaseURL(r); got != "http://gogol.local:8090" {
+		t.Errorf("request host: got %q", got)
+	}
+
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if got := publicBaseURL(r); got != "https://gogol.local:8090" {
+		t.Errorf("X-Forwarded-Proto: got %q", got)
+	}
+	r.Header.Del("X-Forwarded-Proto")
+	r.TLS = &tls.ConnectionState{}
+	if got := publicBaseURL(r); got != "https://gogol.local:8090" {
+		t.Errorf("TLS: got %q", got)
+	}
+
+	t.Setenv("GOGOL_PUBLIC_URL", "https://env.example.com/")
+	if got := publicBaseURL(r); got != "https://env.example.com" {
+		t.Errorf("GOGOL_PUBLIC_URL: got %q", got)
+	}
+
+	if err := database.SetSetting("public_url", "https://settings.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := publicBaseURL(r); got != "https://settings.example.com" {
+		t.Errorf("Settings value: got %q", got)
+	}
+}
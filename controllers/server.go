@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gogol_analytics/database"
+	"gogol_analytics/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limits of the server-side tracking API
+const (
+	maxServerBody  = 1 << 20 // Request body
+	maxServerBatch = 100     // Hits per request
+	maxBackdate    = 7 * 24 * time.Hour
+)
+
+// ServerHit is one page view or custom event submitted by a backend
+type ServerHit struct {
+	Type           string            `json:"type"` // "pageview" (default) or "event"
+	Name           string            `json:"name"` // Custom event name
+	URL            string            `json:"url"`
+	Referrer       string            `json:"referrer"`
+	Title          string            `json:"title"`
+	IP             string            `json:"ip"`         // Original client IP
+	UserAgent      string            `json:"user_agent"` // Original client User-Agent
+	AcceptLanguage string            `json:"accept_language"`
+	Country        string            `json:"country"`
+	CountryCode    string            `json:"country_code"`
+	VisitorID      string            `json:"visitor_id"` // Used by sites in cookie identity mode
+	Timestamp      time.Time         `json:"timestamp"`  // Defaults to the time of the request
+	Props          map[string]string `json:"props"`
+}
+
+// decodeServerHits accepts a single hit or an array of hits
+func decodeServerHits(w http.ResponseWriter, r *http.Request) ([]ServerHit, error) {
+	var raw json.RawMessage
+	r.Body = http.MaxBytesReader(w, r.Body, maxServerBody)
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var hits []ServerHit
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &hits); err != nil {
+			return nil, err
+		}
+	} else {
+		var hit ServerHit
+		if err := json.Unmarshal(raw, &hit); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+
+	if len(hits) == 0 || len(hits) > maxServerBatch {
+		return nil, fmt.Errorf("expected 1 to %d hits, got %d", maxServerBatch, len(hits))
+	}
+	for i, hit := range hits {
+		if hit.URL == "" {
+			return nil, fmt.Errorf("hit %d: url is required", i)
+		}
+		if hit.Type != "" && hit.Type != "pageview" && hit.Type != "event" {
+			return nil, fmt.Errorf("hit %d: unknown type %q", i, hit.Type)
+		}
+		if hit.Type == "event" && hit.Name == "" {
+			return nil, fmt.Errorf("hit %d: name is required for events", i)
+		}
+		// The IP is part of the visitor hash: without it, every hit of a browser would be one visitor
+		if strings.TrimSpace(hit.IP) == "" {
+			return nil, fmt.Errorf("hit %d: ip is required", i)
+		}
+	}
+	return hits, nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// hitTimestamp keeps backdated hits from queues and retries, but never
+// in the future nor older than maxBackdate
+func hitTimestamp(ts, now time.Time) time.Time {
+	if ts.IsZero() || ts.After(now) || now.Sub(ts) > maxBackdate {
+		return now
+	}
+	return ts
+}
+
+// ServerTrack receives page views and custom events from backend applications.
+// The website is identified by its API key instead of the page's domain, so the
+// request carries the original visitor's IP and User-Agent in the body:
+//
+//	POST /api/server/track
+//	Authorization: Bearer API_KEY
+//	{"url": "https://example.com/pricing", "ip": "203.0.113.7", "user_agent": "Mozilla/5.0 ..."}
+func ServerTrack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	website, ok, err := database.GetWebsiteByAPIKey(bearerToken(r))
+	if err != nil {
+		fmt.Printf("DB Error (server track): %v\n", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gogol"`)
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	hits, err := decodeServerHits(w, r)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// All hits are queued or none, so that a retried request is not stored twice
+	now := time.Now()
+	var events []models.Event
+	var custom []models.CustomEvent
+	for _, hit := range hits {
+		event, customEvent := newServerRecord(website, hit, now)
+		if customEvent != nil {
+			custom = append(custom, *customEvent)
+		} else {
+			events = append(events, event)
+		}
+	}
+	if err := database.EnqueueBatch(events, custom); err != nil {
+		if errors.Is(err, database.ErrQueueFull) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service busy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Printf("DB Error (server track): %v\n", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for _, event := range events {
+		sseBroker.Broadcast(event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"accepted": len(hits)})
+}
+
+// IngestServerHit enriches a server-side hit of the website and queues it for the
+// background writer, like a tracker.js hit. Page views are also broadcast to the
+// real-time feed. Hits without a timestamp are recorded at now.
+func IngestServerHit(website models.Website, hit ServerHit, now time.Time) error {
+	event, custom := newServerRecord(website, hit, now)
+	if custom != nil {
+		return database.EnqueueCustomEvent(*custom)
+	}
+
+	if err := database.EnqueueEvent(event); err != nil {
+		return err
+	}
+	sseBroker.Broadcast(event)
+	return nil
+}
+
+// newServerRecord builds what a hit stores: its page view event, or its custom
+// event when custom is not nil
+func newServerRecord(website models.Website, hit ServerHit, now time.Time) (event models.Event, custom *models.CustomEvent) {
+	event = NewServerEvent(website, hit)
+	event.Timestamp = hitTimestamp(hit.Timestamp, now)
+
+	if hit.Type == "event" {
+		return event, &models.CustomEvent{
+			WebsiteID: website.ID,
+			Timestamp: event.Timestamp,
+			VisitorID: event.VisitorID,
+			Name:      hit.Name,
+			Path:      event.NormalizedPath,
+			Props:     hit.Props,
+		}
+	}
+	return event, nil
+}
+
+// NewServerEvent builds the enriched page view event of a hit of the website.
+// The hit's timestamp is copied as is; the source type is SourceServer.
+func NewServerEvent(website models.Website, hit ServerHit) models.Event {
+	event := models.Event{
+		Timestamp:   hit.Timestamp,
+		VisitorID:   hit.VisitorID,
+		Country:     hit.Country,
+		CountryCode: hit.CountryCode,
+		UserAgent:   hit.UserAgent,
+		Referrer:    hit.Referrer,
+		CurrentURL:  hit.URL,
+		PageTitle:   hit.Title,
+		Language:    preferredLanguage(hit.AcceptLanguage),
+		SourceType:  models.SourceServer,
+		IsBot:       models.IsBotUserAgent(hit.UserAgent),
+	}
+	if event.Country == "" {
+		event.Country, event.CountryCode = "unknown", "unknown"
+	}
+	event.ScreenResolution = "unknown"
+	EnrichEvent(&event, website, hit.IP)
+	return event
+}
+
+// BroadcastEvent sends an event recorded outside the HTTP handlers (e.g. a tailed
+// access log line) to the dashboards following the real-time feed
+func BroadcastEvent(event models.Event) {
+	sseBroker.Broadcast(event)
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"gogol_analytics/database"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeServerHits(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		hits int
+		err  string
+	}{
+		{"single hit", `{"url":"https://example.com/","ip":"203.0.113.7"}`, 1, ""},
+		{"batch", `[{"url":"https://example.com/","ip":"203.0.113.7"},{"type":"event","name":"signup","url":"https://example.com/join","ip":"203.0.113.7"}]`, 2, ""},
+		{"empty batch", `[]`, 0, "expected 1 to 100 hits"},
+		{"too many hits", "[" + strings.Repeat(`{"url":"https://example.com/","ip":"203.0.113.7"},`, maxServerBatch) + `{"url":"https://example.com/","ip":"203.0.113.7"}]`, 0, "expected 1 to 100 hits"},
+		{"missing url", `{"ip":"203.0.113.7"}`, 0, "hit 0: url is required"},
+		{"missing ip", `[{"url":"https://example.com/","ip":"203.0.113.7"},{"url":"https://example.com/","ip":" "}]`, 0, "hit 1: ip is required"},
+		{"unknown type", `{"type":"click","url":"https://example.com/","ip":"203.0.113.7"}`, 0, `hit 0: unknown type "click"`},
+		{"event without name", `{"type":"event","url":"https://example.com/","ip":"203.0.113.7"}`, 0, "hit 0: name is required for events"},
+		{"invalid JSON", `{"url":`, 0, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/server/track", strings.NewReader(tt.body))
+		hits, err := decodeServerHits(httptest.NewRecorder(), r)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || len(hits) != tt.hits {
+			t.Errorf("%s: got %d hits (%v), want %d", tt.name, len(hits), err, tt.hits)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer abc":       "abc",
+		"bearer  abc ":     "abc",
+		"Basic dXNlcjpwdw": "",
+		"abc":              "",
+		"":                 "",
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Authorization", header)
+		if got := bearerToken(r); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestHitTimestamp(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		ts     time.Time
+		wantTS time.Time
+	}{
+		{"missing", time.Time{}, now},
+		{"backdated", now.Add(-time.Hour), now.Add(-time.Hour)},
+		{"at the backdate limit", now.Add(-maxBackdate), now.Add(-maxBackdate)},
+		{"too old", now.Add(-maxBackdate - time.Second), now},
+		{"in the future", now.Add(time.Minute), now},
+	}
+	for _, tt := range tests {
+		if got := hitTimestamp(tt.ts, now); !got.Equal(tt.wantTS) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.wantTS)
+		}
+	}
+}
+
+func TestServerTrack(t *testing.T) {
+	setupTestDB(t)
+	if err := database.AddWebsite("Example", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	websites, err := database.GetWebsites()
+	if err != nil || len(websites) != 1 {
+		t.Fatalf("expected one website, got %v (%v)", websites, err)
+	}
+	if err := database.SetWebsiteAPIKey(websites[0].ID, "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/server/track", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		ServerTrack(w, r)
+		return w
+	}
+	hits := func(n int) string {
+		return "[" + strings.TrimSuffix(strings.Repeat(`{"url":"https://example.com/","ip":"203.0.113.7","user_agent":"Mozilla/5.0"},`, n), ",") + "]"
+	}
+	countEvents := func() int {
+		var n int
+		if err := database.DB.QueryRow("SELECT COUNT(*) FROM events").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if w := post("", hits(1)); w.Code != 401 || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("missing key: got %d", w.Code)
+	}
+	if w := post("wrong", hits(1)); w.Code != 401 {
+		t.Errorf("unknown key: got %d", w.Code)
+	}
+	if w := post("secret", `{"url":"https://example.com/"}`); w.Code != 400 {
+		t.Errorf("hit without ip: got %d", w.Code)
+	}
+
+	batch := `[{"url":"https://other.com/a","ip":"203.0.113.7","user_agent":"Mozilla/5.0"},
+		{"type":"event","name":"signup","url":"https://example.com/join","ip":"203.0.113.7","props":{"plan":"pro"}}]`
+	if w := post("secret", batch); w.Code != 202 || strings.TrimSpace(w.Body.String()) != `{"accepted":2}` {
+		t.Errorf("batch: got %d %s", w.Code, w.Body.String())
+	}
+	var custom int
+	database.DB.QueryRow("SELECT COUNT(*) FROM custom_events WHERE name = 'signup'").Scan(&custom)
+	if n := countEvents(); n != 1 || custom != 1 {
+		t.Errorf("expected 1 page view and 1 custom event, got %d and %d", n, custom)
+	}
+
+	// A batch is queued whole or not at all
+	database.StartWriter(database.WriterConfig{QueueSize: 3, BatchSize: 1, FlushInterval: time.Hour})
+	if w := post("secret", hits(4)); w.Code != 503 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("batch larger than the queue: got %d", w.Code)
+	}
+	if depth := database.GetQueueStats().Depth; depth != 0 {
+		t.Errorf("expected nothing queued from a rejected batch, got %d", depth)
+	}
+	if w := post("secret", hits(3)); w.Code != 202 {
+		t.Errorf("batch that fits: got %d", w.Code)
+	}
+	database.StopWriter()
+	if n := countEvents(); n != 4 {
+		t.Errorf("expected the rejected batch not to be stored, got %d events", n)
+	}
+}
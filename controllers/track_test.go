@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"bytes"
+	"gogol_analytics/database"
+	"gogol_analytics/models"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// multipartBody encodes fields as multipart/form-data, like sendBeacon with FormData
+func multipartBody(t *testing.T, fields map[string]string) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return mw.FormDataContentType(), buf.String()
+}
+
+func TestTrackPayloadEncodings(t *testing.T) {
+	setupTestDB(t)
+	if err := database.AddWebsite("Example", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	const data = `{"current_url":"https://example.com/pricing","page_title":"Pricing","screen_resolution":"1920x1080","language":"fr-CA"}`
+	fields := map[string]string{
+		"current_url":       "https://example.com/pricing",
+		"page_title":        "Pricing",
+		"screen_resolution": "1920x1080",
+		"language":          "fr-CA",
+	}
+	form := url.Values{}
+	for name, value := range fields {
+		form.Set(name, value)
+	}
+	multipartFields, multipartFieldsBody := multipartBody(t, fields)
+	multipartData, multipartDataBody := multipartBody(t, map[string]string{"data": data})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"json", "application/json", data, 204},
+		{"text/plain beacon", "text/plain;charset=UTF-8", data, 204},
+		{"untyped body", "", data, 204},
+		{"urlencoded fields", "application/x-www-form-urlencoded", form.Encode(), 204},
+		{"urlencoded data", "application/x-www-form-urlencoded", url.Values{"data": {data}}.Encode(), 204},
+		{"multipart fields", multipartFields, multipartFieldsBody, 204},
+		{"multipart data", multipartData, multipartDataBody, 204},
+		{"invalid json", "text/plain", "{", 400},
+		{"invalid data field", "application/x-www-form-urlencoded", "data=%7B", 400},
+		{"another domain", "application/json", `{"current_url":"https://other.com/"}`, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database.ClearAllEvents()
+			r := httptest.NewRequest("POST", "/api/track", bytes.NewBufferString(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+			w := httptest.NewRecorder()
+			Track(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+
+			events, err := database.GetRecentEvents(database.Query{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != 204 {
+				if len(events) != 0 {
+					t.Errorf("expected the hit to be dropped, got %+v", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("expected one event, got %d", len(events))
+			}
+			e := events[0]
+			if e.CurrentURL != "https://example.com/pricing" || e.PageTitle != "Pricing" ||
+				e.ScreenResolution != "1920x1080" || e.SourceType != models.SourceJS ||
+				e.Language != "fr" || e.Locale != "fr-CA" {
+				t.Errorf("unexpected event %+v", e)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"gogol_analytics/database"
+	"gogol_analytics/models"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrafficSite(t *testing.T) {
+	setupTestDB(t)
+	t.Chdir("..") // templates are read from views/
+
+	// AddWebsite derives IDs from the clock, so two websites get fixed ones here
+	for _, site := range []struct{ id, url, zone string }{
+		{"TOKYO", "https://tokyo.example.com", "Asia/Tokyo"},
+		{"NY", "https://ny.example.com", "America/New_York"},
+	} {
+		if _, err := database.DB.Exec("INSERT INTO websites (id, name, url, created_at) VALUES (?, ?, ?, ?)",
+			site.id, site.id, site.url, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.SetWebsiteTimeZone(site.id, site.zone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	err := database.InsertEvents([]models.Event{
+		{WebsiteID: "TOKYO", Timestamp: now.Add(-time.Hour), VisitorID: "a", NormalizedPath: "/tokyo-page"},
+		{WebsiteID: "NY", Timestamp: now.Add(-time.Hour), VisitorID: "b", NormalizedPath: "/ny-page"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) string {
+		w := httptest.NewRecorder()
+		Traffic(w, httptest.NewRequest("GET", "/?"+query, nil))
+		if w.Code != 200 {
+			t.Fatalf("%s: got %d %s", query, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	// Every website: the zones differ, so days follow the server's
+	body := get("range=7d")
+	if !strings.Contains(body, `placeholder="Server time"`) {
+		t.Errorf("expected the server's time zone for every website")
+	}
+	if !strings.Contains(body, "/tokyo-page") || !strings.Contains(body, "/ny-page") {
+		t.Errorf("expected the pages of every website")
+	}
+
+	// One website: its zone and its events only, kept by the page's links
+	body = get("range=7d&site=TOKYO")
+	if !strings.Contains(body, `placeholder="Asia/Tokyo"`) {
+		t.Errorf("expected the Tokyo website's time zone")
+	}
+	if !strings.Contains(body, "/tokyo-page") || strings.Contains(body, "/ny-page") {
+		t.Errorf("expected the pages of the Tokyo website only")
+	}
+	if !strings.Contains(body, "site=TOKYO") {
+		t.Errorf("expected links to keep the site")
+	}
+
+	// An unknown website falls back to every website
+	if body = get("range=7d&site=nope"); !strings.Contains(body, "/ny-page") {
+		t.Errorf("expected every website for an unknown site")
+	}
+}
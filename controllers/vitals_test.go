@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"gogol_analytics/database"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClampVital(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		in   *float64
+		want *float64
+	}{
+		{"missing", nil, nil},
+		{"zero", value(0), value(0)},
+		{"in range", value(1200), value(1200)},
+		{"at the maximum", value(60000), value(60000)},
+		{"above the maximum", value(60000.5), nil},
+		{"negative", value(-1), nil},
+		{"not a number", value(math.NaN()), nil},
+	}
+	for _, tt := range tests {
+		got := clampVital(tt.in, 60000)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVitalsHandler(t *testing.T) {
+	setupTestDB(t)
+	if err := database.AddWebsite("Example", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+	}{
+		{"sample", "POST", `{"current_url":"https://example.com/a?x=1","lcp":1800,"cls":0.02,"inp":-5}`, 204},
+		{"nothing plausible", "POST", `{"current_url":"https://example.com/a","lcp":500000}`, 204},
+		{"unknown domain", "POST", `{"current_url":"https://other.com/","lcp":1800}`, 403},
+		{"invalid JSON", "POST", `{"lcp":`, 400},
+		{"wrong method", "GET", "", 405},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/api/vitals", strings.NewReader(tt.body))
+		r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+		w := httptest.NewRecorder()
+		Vitals(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+
+	// Only the first sample is stored, without its negative INP
+	for metric, samples := range map[string]int{"LCP": 1, "CLS": 1, "INP": 0} {
+		rows, err := database.GetVitalsBreakdown("24h", metric, "path", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := 0
+		for _, row := range rows {
+			got += row.Summary.Samples
+			if row.Key != "/a" {
+				t.Errorf("%s: expected the normalized path /a, got %q", metric, row.Key)
+			}
+		}
+		if got != samples {
+			t.Errorf("%s: got %d samples, want %d", metric, got, samples)
+		}
+	}
+}
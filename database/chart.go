@@ -0,0 +1,190 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gogol_analytics/models"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxChartPoints bounds the number of buckets a chart query may ask for
+const maxChartPoints = 2000
+
+// GetChartData buckets the query's events by "hour" or "day" from q.From to q.To.
+// Events are counted by grouped SQL, one index range per bucket; buckets without
+// events are left at zero.
+func GetChartData(q Query, interval string) ([]models.ChartDataPoint, error) {
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	starts, err := chartStarts(q, interval)
+	if err != nil {
+		return nil, err
+	}
+	points := len(starts)
+
+	where, args, err := q.where()
+	if err != nil {
+		return nil, err
+	}
+
+	// Bucket bounds as stored timestamps (UTC text), the first one clamped to the start
+	// of the range and the last one closing it
+	bounds := make([]string, 0, points+1)
+	for _, t := range starts {
+		bounds = append(bounds, storedTime(t))
+	}
+	bounds[0] = storedTime(q.From)
+	bounds = append(bounds, storedTime(q.To))
+	boundsJSON, err := json.Marshal(bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	// CROSS JOIN keeps buckets as the outer loop so that each one reads its own
+	// timestamp range from the index. Visitors are counted once per bucket; those
+	// whose first visit ever (see the visitors table) falls in the bucket are new.
+	// Such a visitor has a view in the bucket, so without filters they are counted
+	// from the first_seen index alone. Filtered charts match them against the
+	// bucket's events through a temporary index.
+	newVisitors := `
+		new_visitors AS MATERIALIZED (
+			SELECT bucket AS new_bucket, website_id AS new_site, visitor_id AS new_visitor
+			FROM buckets JOIN visitors v
+			WHERE bucket_end IS NOT NULL AND first_seen >= bucket_start AND first_seen < bucket_end
+				AND v.website_id = COALESCE(NULLIF(?, ''), v.website_id)
+		)`
+	newCount := "COUNT(DISTINCT CASE WHEN is_bot = 0 THEN new_visitor END)"
+	join := `LEFT JOIN new_visitors ON new_bucket = bucket
+			AND new_site = COALESCE(website_id, '') AND new_visitor = visitor_id`
+	if len(q.Filters) == 0 {
+		newVisitors = `
+		new_visitors AS MATERIALIZED (
+			SELECT bucket AS new_bucket, COUNT(DISTINCT visitor_id) AS new_count
+			FROM buckets JOIN visitors v
+			WHERE bucket_end IS NOT NULL AND first_seen >= bucket_start AND first_seen < bucket_end
+				AND v.website_id = COALESCE(NULLIF(?, ''), v.website_id)
+			GROUP BY bucket
+		)`
+		newCount = "COALESCE((SELECT new_count FROM new_visitors WHERE new_bucket = bucket), 0)"
+		join = ""
+	}
+	rows, err := DB.Query(`
+		WITH buckets AS (
+			SELECT key AS bucket, value AS bucket_start,
+				LEAD(value) OVER (ORDER BY key) AS bucket_end
+			FROM json_each(?)
+		),`+newVisitors+`
+		SELECT bucket, COUNT(*), COALESCE(SUM(is_bot), 0),
+			COUNT(DISTINCT CASE WHEN is_bot = 0 THEN visitor_id END),
+			`+newCount+`
+		FROM buckets CROSS JOIN events
+		`+join+`
+		WHERE bucket_end IS NOT NULL AND timestamp >= bucket_start AND timestamp < bucket_end
+			AND `+where+`
+		GROUP BY bucket
+	`, append([]any{string(boundsJSON), q.WebsiteID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Initialize buckets with labels
+	buckets := make([]models.ChartDataPoint, points)
+	layout := "02 Jan"
+	switch {
+	case interval == "hour":
+		layout = "15:00"
+	case points <= 7:
+		layout = "Mon"
+	case starts[0].Year() != q.To.Add(-time.Nanosecond).In(q.location()).Year():
+		layout = "02 Jan 2006"
+	}
+	for i := range buckets {
+		buckets[i].Time = starts[i]
+		buckets[i].Label = starts[i].Format(layout)
+	}
+
+	for rows.Next() {
+		var index, views, bots, visitors, newVisitors int
+		if err := rows.Scan(&index, &views, &bots, &visitors, &newVisitors); err != nil {
+			return nil, err
+		}
+		if index >= 0 && index < points {
+			buckets[index].Views = views
+			buckets[index].Bots = bots
+			buckets[index].Visitors = visitors
+			buckets[index].NewVisitors = newVisitors
+			buckets[index].ReturningVisitors = visitors - newVisitors
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Daily charts also show the traffic imported from other tools for days before tracking
+	if interval != "hour" {
+		imported, err := importedDaily(q)
+		if err != nil {
+			return nil, err
+		}
+		for i := range buckets {
+			day := buckets[i].Time.Format("2006-01-02")
+			if stat, ok := imported[day]; ok {
+				buckets[i].Views += stat.Pageviews
+				buckets[i].ImportedVisitors += stat.Visitors
+			}
+		}
+	}
+
+	return buckets, nil
+}
+
+// CheckChartRange returns the error GetChartData would give for the range and
+// interval, without querying events
+func CheckChartRange(q Query, interval string) error {
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	_, err := chartStarts(q, interval)
+	return err
+}
+
+// chartStarts validates the chart range and returns its bucket starts
+func chartStarts(q Query, interval string) ([]time.Time, error) {
+	if q.From.IsZero() || !q.To.After(q.From) {
+		return nil, fmt.Errorf("chart range needs a start before its end")
+	}
+	return bucketStarts(q, interval)
+}
+
+// storedTime formats a time like the driver stores timestamps, so that SQL can
+// compare it with the timestamp column as text
+func storedTime(t time.Time) string {
+	return t.UTC().Format(sqlite3.SQLiteTimestampFormats[0])
+}
+
+// bucketStarts returns the start of each chart bucket between q.From and q.To in the
+// query's time zone: whole hours, or midnights so that days last 23 or 25 hours
+// across DST changes
+func bucketStarts(q Query, interval string) ([]time.Time, error) {
+	loc := q.location()
+	from := q.From.In(loc)
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	next := func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	if interval == "hour" {
+		start = from.Truncate(time.Minute).Add(-time.Duration(from.Minute()) * time.Minute)
+		next = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	}
+
+	var starts []time.Time
+	for t := start; t.Before(q.To); t = next(t) {
+		if len(starts) == maxChartPoints {
+			return nil, fmt.Errorf("too many %s buckets (more than %d)", interval, maxChartPoints)
+		}
+		starts = append(starts, t)
+	}
+	return starts, nil
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"fmt"
+	"gogol_analytics/models"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChartDataFillsGaps(t *testing.T) {
+	setupTestDB(t)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	err := InsertEvents([]models.Event{
+		{WebsiteID: "site", Timestamp: day.Add(2 * time.Hour), VisitorID: "a"},
+		{WebsiteID: "site", Timestamp: day.Add(3 * time.Hour), VisitorID: "a"},
+		{WebsiteID: "site", Timestamp: day.Add(3 * time.Hour), VisitorID: "bot", IsBot: true},
+		{WebsiteID: "site", Timestamp: day.AddDate(0, 0, 3), VisitorID: "b"},
+		{WebsiteID: "site", Timestamp: day.AddDate(0, 0, 3).Add(time.Hour), VisitorID: "a"},
+		{WebsiteID: "other", Timestamp: day.AddDate(0, 0, 1), VisitorID: "c"},
+		// Logged before the range but imported last: "b" was already a visitor
+		{WebsiteID: "site", Timestamp: day.AddDate(0, -1, 0), VisitorID: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := Query{WebsiteID: "site", From: day, To: day.AddDate(0, 0, 5), Location: time.UTC}
+	points, err := GetChartData(q, "day")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.ChartDataPoint{
+		{Views: 3, Visitors: 1, NewVisitors: 1, Bots: 1},
+		{}, {},
+		{Views: 2, Visitors: 2, ReturningVisitors: 2},
+		{},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("expected %d buckets, got %d", len(want), len(points))
+	}
+	for i, p := range points {
+		w := want[i]
+		if p.Views != w.Views || p.Visitors != w.Visitors || p.NewVisitors != w.NewVisitors ||
+			p.ReturningVisitors != w.ReturningVisitors || p.Bots != w.Bots {
+			t.Errorf("bucket %d (%s): got %+v, want %+v", i, p.Label, p, w)
+		}
+		if !p.Time.Equal(day.AddDate(0, 0, i)) {
+			t.Errorf("bucket %d starts %v", i, p.Time)
+		}
+	}
+
+	if n, err := CountVisitors(q); err != nil || n != 2 {
+		t.Errorf("expected 2 distinct visitors over the range, got %d (%v)", n, err)
+	}
+}
+
+func TestChartDataNewVisitorsWithFilters(t *testing.T) {
+	setupTestDB(t)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	err := InsertEvents([]models.Event{
+		// "a" first visits from Germany, then from France the same day
+		{WebsiteID: "site", Timestamp: day.Add(time.Hour), VisitorID: "a", Country: "Germany"},
+		{WebsiteID: "site", Timestamp: day.Add(2 * time.Hour), VisitorID: "a", Country: "France"},
+		{WebsiteID: "site", Timestamp: day.Add(time.Hour), VisitorID: "b", Country: "Germany"},
+		{WebsiteID: "other", Timestamp: day.Add(time.Hour), VisitorID: "c", Country: "France"},
+		{WebsiteID: "other", Timestamp: day.AddDate(0, 0, 1), VisitorID: "c", Country: "France"},
+		// A hashed visitor ID is the same on every website
+		{WebsiteID: "site", Timestamp: day.Add(3 * time.Hour), VisitorID: "d"},
+		{WebsiteID: "other", Timestamp: day.Add(4 * time.Hour), VisitorID: "d"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	france := []models.Filter{{Dimension: "country", Op: models.FilterEquals, Value: "France"}}
+	tests := []struct {
+		name  string
+		q     Query
+		start time.Duration
+		want  []models.ChartDataPoint
+	}{
+		{"website", Query{WebsiteID: "site"}, 0, []models.ChartDataPoint{
+			{Views: 4, Visitors: 3, NewVisitors: 3}, {},
+		}},
+		{"filtered", Query{WebsiteID: "site", Filters: france}, 0, []models.ChartDataPoint{
+			{Views: 1, Visitors: 1, NewVisitors: 1}, {},
+		}},
+		{"every website", Query{}, 0, []models.ChartDataPoint{
+			{Views: 6, Visitors: 4, NewVisitors: 4}, {Views: 1, Visitors: 1, ReturningVisitors: 1},
+		}},
+		// "a" was first seen before the range starts, within its first bucket
+		{"range starting within a bucket", Query{WebsiteID: "site"}, 90 * time.Minute, []models.ChartDataPoint{
+			{Views: 2, Visitors: 2, NewVisitors: 1, ReturningVisitors: 1}, {},
+		}},
+	}
+	for _, tt := range tests {
+		q := tt.q
+		q.From, q.To, q.Location = day.Add(tt.start), day.AddDate(0, 0, 2), time.UTC
+		points, err := GetChartData(q, "day")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != len(tt.want) {
+			t.Fatalf("%s: expected %d buckets, got %d", tt.name, len(tt.want), len(points))
+		}
+		for i, p := range points {
+			w := tt.want[i]
+			if p.Views != w.Views || p.Visitors != w.Visitors || p.NewVisitors != w.NewVisitors ||
+				p.ReturningVisitors != w.ReturningVisitors {
+				t.Errorf("%s: bucket %d: got %+v, want %+v", tt.name, i, p, w)
+			}
+		}
+	}
+}
+
+// seedBenchmarkDB fills a database with GOGOL_BENCH_EVENTS (default 1,000,000) events
+// spread over the last 400 days of two websites
+func seedBenchmarkDB(b *testing.B) {
+	b.Helper()
+	n := 1000000
+	if env := os.Getenv("GOGOL_BENCH_EVENTS"); env != "" {
+		var err error
+		if n, err = strconv.Atoi(env); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	os.Setenv("DB_PATH", filepath.Join(b.TempDir(), "gogol_bench.db"))
+	InitDB()
+	b.Cleanup(func() { DB.Close() })
+
+	rng := rand.New(rand.NewSource(1))
+	browsers := []string{"Chrome", "Firefox", "Safari", "Edge"}
+	countries := []string{"France", "Germany", "United States", "Japan", "Brazil"}
+	now := time.Now()
+	const batch = 10000
+	for done := 0; done < n; done += batch {
+		events := make([]models.Event, 0, batch)
+		for i := done; i < n && i < done+batch; i++ {
+			page := fmt.Sprintf("/page/%d", rng.Intn(200))
+			events = append(events, models.Event{
+				WebsiteID:      []string{"SITE_A", "SITE_B"}[rng.Intn(2)],
+				Timestamp:      now.Add(-time.Duration(rng.Int63n(int64(400 * 24 * time.Hour)))),
+				VisitorID:      strconv.Itoa(rng.Intn(n/10 + 1)),
+				IsBot:          rng.Intn(20) == 0,
+				Browser:        browsers[rng.Intn(len(browsers))],
+				Country:        countries[rng.Intn(len(countries))],
+				CurrentURL:     "https://example.com" + page,
+				NormalizedPath: page,
+				Path:           page,
+			})
+		}
+		if err := InsertEvents(events); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+}
+
+func BenchmarkGetChartData(b *testing.B) {
+	seedBenchmarkDB(b)
+
+	for _, timeRange := range []string{"24h", "30d", "ytd", "all"} {
+		q, interval := RangeQuery(timeRange, time.UTC)
+		q.WebsiteID = "SITE_A"
+		b.Run(timeRange, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := GetChartData(q, interval); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTrafficPage runs the queries of the Traffic page for every website. With
+// 1,000,000 events on one CPU the 30d page takes about 0.17s and ytd about 2s, most of
+// it in the chart and CountVisitors counting distinct visitors from the timestamp index.
+func BenchmarkTrafficPage(b *testing.B) {
+	seedBenchmarkDB(b)
+
+	dimensions := []string{"page", "country", "device", "os", "source", "referrer",
+		"browser", "screen", "keyword", "source_type", "language"}
+	for _, timeRange := range []string{"30d", "ytd"} {
+		q, interval := RangeQuery(timeRange, time.UTC)
+		b.Run(timeRange, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q := q.Plan()
+				if _, err := GetChartData(q, interval); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := CountVisitors(q); err != nil {
+					b.Fatal(err)
+				}
+				for _, dimension := range dimensions {
+					if _, err := GetTopStats(q, dimension, 10); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if _, err := GetEntryPages(q, 10); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := GetExitPages(q, 10); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := GetRecentEvents(q, 20); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
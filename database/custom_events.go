@@ -0,0 +1,61 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gogol_analytics/models"
+)
+
+func insertCustomEvents(tx *sql.Tx, events []models.CustomEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO custom_events (website_id, timestamp, visitor_id, name, path, props)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		props := ""
+		if len(e.Props) > 0 {
+			b, err := json.Marshal(e.Props)
+			if err != nil {
+				return err
+			}
+			props = string(b)
+		}
+		if _, err := stmt.Exec(e.WebsiteID, e.Timestamp.UTC(), e.VisitorID, e.Name, e.Path, props); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTopCustomEvents counts custom events by name over the time range
+func GetTopCustomEvents(timeRange string, limit int) ([]models.TableRow, error) {
+	rows, err := DB.Query(`
+		SELECT name, COUNT(*) as count
+		FROM custom_events
+		WHERE timestamp >= ?
+		GROUP BY name
+		ORDER BY count DESC
+		LIMIT ?
+	`, rangeStart(timeRange), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.TableRow
+	for rows.Next() {
+		var row models.TableRow
+		if err := rows.Scan(&row.Key, &row.Value); err != nil {
+			continue
+		}
+		stats = append(stats, row)
+	}
+	return stats, nil
+}
@@ -4,17 +4,41 @@ import (
 	"database/sql"
 	"fmt"
 	"gogol_analytics/models"
+	"gogol_analytics/urlnorm"
 	"log"
+	"os"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
+// driverName is the SQLite driver with the functions dashboard filters need
+const driverName = "sqlite3_gogol"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", sqlRegexp, true)
+		},
+	})
+}
+
 func InitDB() {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "./gogol.db"
+	}
+
+	// WAL lets dashboard reads proceed while the background writer commits,
+	// and the busy timeout absorbs the occasional overlap with settings writes.
+	// Timestamps are stored in UTC and read back in the server's zone (_loc).
+	// A 64 MiB page cache (default 2 MiB) keeps index and row pages of dashboard
+	// ranges in memory between queries.
 	var err error
-	DB, err = sql.Open("sqlite3", "./gogol.db")
+	DB, err = sql.Open(driverName, dbPath+"?_busy_timeout=5000&_journal_mode=WAL&_loc=auto&_cache_size=-65536")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -56,178 +80,218 @@ func InitDB() {
 		log.Fatal(err)
 	}
 	stmtEvents.Exec()
+
+	createSettingsTableSQL := `CREATE TABLE IF NOT EXISTS settings (
+		"key" TEXT NOT NULL PRIMARY KEY,
+		"value" TEXT
+	);`
+
+	stmtSettings, err := DB.Prepare(createSettingsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stmtSettings.Exec()
+
+	migrate()
 }
 
 func InsertEvent(e models.Event) error {
-	stmt, err := DB.Prepare(`INSERT INTO events (
-		website_id, timestamp, visitor_id, country, country_code, ip_hash, user_agent, 
-		screen_resolution, referrer, current_url, is_bot, os, browser, device, keyword
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	return InsertEvents([]models.Event{e})
+}
+
+// InsertEvents writes a batch of events in a single transaction
+func InsertEvents(events []models.Event) error {
+	return inTx(func(tx *sql.Tx) error {
+		return insertEvents(tx, events)
+	})
+}
+
+// inTx runs fn in a transaction, committing if it succeeds and rolling back otherwise
+func inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := DB.Begin()
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(
-		e.WebsiteID, e.Timestamp, e.VisitorID, e.Country, e.CountryCode, e.IPHash, e.UserAgent,
-		e.ScreenResolution, e.Referrer, e.CurrentURL, e.IsBot, e.OS, e.Browser, e.Device, e.Keyword,
-	)
-	return err
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-// ClearAllEvents deletes all events from the database
-func ClearAllEvents() error {
-	_, err := DB.Exec("DELETE FROM events")
-	return err
-}
+func insertEvents(tx *sql.Tx, events []models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
 
-// GetChartData retrieves traffic data for the chart based on the time range
-func GetChartData(timeRange string) ([]models.ChartDataPoint, error) {
-	var points int
+	stmt, err := tx.Prepare(`INSERT INTO events (
+		website_id, timestamp, visitor_id, country, country_code, ip_hash, user_agent, 
+		screen_resolution, referrer, current_url, is_bot, os, browser, device, keyword,
+		source_type, page_title, normalized_path,
+		hostname, path, query_string, referrer_host, referrer_path, language, locale,
+		session_entry, session_exit
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
 
-	// Determine parameters based on range
-	switch timeRange {
-	case "7d":
-		points = 7
-	case "30d":
-		points = 30
-	default: // 24h
-		points = 24
+	// Earliest human view of each visitor; imported logs may arrive out of order
+	visitorStmt, err := tx.Prepare(`INSERT INTO visitors (website_id, visitor_id, first_seen) VALUES (?, ?, ?)
+		ON CONFLICT (website_id, visitor_id) DO UPDATE SET first_seen = excluded.first_seen
+		WHERE excluded.first_seen < visitors.first_seen`)
+	if err != nil {
+		return err
 	}
+	defer visitorStmt.Close()
 
-	// We need to generate a list of all time slots first to fill gaps (Left Join approach is complex in simple Go/SQLite)
-	// Alternatively, we fetch all data in range and bucket it in Go. This is easier and likely fast enough for this scale.
+	titleStmt, err := tx.Prepare(`INSERT INTO page_titles (website_id, path, title, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (website_id, path) DO UPDATE SET title = excluded.title, updated_at = excluded.updated_at
+		WHERE excluded.updated_at >= page_titles.updated_at`)
+	if err != nil {
+		return err
+	}
+	defer titleStmt.Close()
 
-	startLimit := time.Now()
-	if timeRange == "24h" {
-		startLimit = startLimit.Add(-24 * time.Hour)
-	} else if timeRange == "7d" {
-		startLimit = startLimit.AddDate(0, 0, -7)
-	} else {
-		startLimit = startLimit.AddDate(0, 0, -30)
+	rollups, err := prepareRollupWriter(tx)
+	if err != nil {
+		return err
 	}
+	defer rollups.Close()
 
-	rows, err := DB.Query(`
-		SELECT timestamp, is_bot, visitor_id
-		FROM events 
-		WHERE timestamp >= ? 
-		ORDER BY timestamp ASC
-	`, startLimit)
+	sessions, err := prepareSessionWriter(tx, rollups)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer sessions.Close()
 
-	// Bucket Logic
-	buckets := make([]models.ChartDataPoint, points)
-	now := time.Now()
-
-	// Initialize buckets with labels
-	for i := 0; i < points; i++ {
-		var label string
-		if timeRange == "24h" {
-			// Current hour back to -23h
-			t := now.Add(time.Duration(-(points - 1 - i)) * time.Hour)
-			label = t.Format("15:00")
-			buckets[i].Label = label
-		} else {
-			// Current day back to -N days
-			t := now.AddDate(0, 0, -(points - 1 - i))
-			label = t.Format("02 Jan") // Or Mon for 7d
-			if timeRange == "7d" {
-				label = t.Format("Mon")
+	for _, e := range events {
+		var entry, exit bool
+		if sessionView(e) {
+			if entry, exit, err = sessions.flags(e); err != nil {
+				return err
 			}
-			buckets[i].Label = label
 		}
-	}
 
-	// Process rows
-	seenVisitors := make(map[string]bool) // Unique for this query
-
-	for rows.Next() {
-		var ts time.Time
-		var isBot bool
-		var vid string
-		if err := rows.Scan(&ts, &isBot, &vid); err != nil {
-			continue
+		if !e.IsBot && e.VisitorID != "" {
+			if _, err := visitorStmt.Exec(e.WebsiteID, e.VisitorID, e.Timestamp.UTC()); err != nil {
+				return err
+			}
 		}
 
-		// Find bucket index
-		var index int
-		if timeRange == "24h" {
-			hoursAgo := int(now.Sub(ts).Hours())
-			index = points - 1 - hoursAgo
-		} else {
-			daysAgo := int(now.Sub(ts).Hours() / 24)
-			index = points - 1 - daysAgo
+		// Keep the latest title seen for each page
+		if e.PageTitle != "" && e.NormalizedPath != "" {
+			if _, err := titleStmt.Exec(e.WebsiteID, e.NormalizedPath, e.PageTitle, e.Timestamp.UTC()); err != nil {
+				return err
+			}
 		}
 
-		if index >= 0 && index < points {
-			buckets[index].Views++
-			if isBot {
-				buckets[index].Bots++
-			} else {
-				if !seenVisitors[vid] {
-					buckets[index].NewVisitors++
-					seenVisitors[vid] = true
-				} else {
-					buckets[index].ReturningVisitors++
-				}
+		res, err := stmt.Exec(
+			e.WebsiteID, e.Timestamp.UTC(), e.VisitorID, e.Country, e.CountryCode, e.IPHash, e.UserAgent,
+			e.ScreenResolution, e.Referrer, e.CurrentURL, e.IsBot, e.OS, e.Browser, e.Device, e.Keyword,
+			sourceTypeOrDefault(e.SourceType), e.PageTitle, e.NormalizedPath,
+			e.Hostname, e.Path, e.QueryString, e.ReferrerHost, e.ReferrerPath, e.Language, e.Locale,
+			entry, exit,
+		)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := rollups.add(id); err != nil {
+			return err
+		}
+		if sessionView(e) {
+			if err := rollups.addSession(e.WebsiteID, e.NormalizedPath, e.Timestamp, 1, count(entry), count(exit), count(entry && exit)); err != nil {
+				return err
 			}
 		}
 	}
+	return nil
+}
 
-	return buckets, nil
+// count is 1 for true and 0 for false
+func count(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-// GetTopStatsGeneric aggregates counts for a specific column
-func GetTopStats(column string, limit int) ([]models.TableRow, error) {
-	// Safelist columns to prevent SQL injection
-	allowed := map[string]bool{
-		"current_url": true, "country": true, "os": true, "browser": true,
-		"screen_resolution": true, "referrer": true, "keyword": true, "device": true,
+func sourceTypeOrDefault(sourceType string) string {
+	if sourceType == "" {
+		return models.SourceJS
 	}
-	if !allowed[column] {
-		return nil, fmt.Errorf("invalid column")
+	return sourceType
+}
+
+// ClearAllEvents deletes all events, and the visitors and rollups they recorded, from the database
+func ClearAllEvents() error {
+	return inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM visitors"); err != nil {
+			return err
+		}
+		for _, r := range rollups {
+			if _, err := tx.Exec("DELETE FROM " + r.table); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec("DELETE FROM events")
+		return err
+	})
+}
+
+// rangeStart returns the beginning of a dashboard time range ("24h", "7d" or "30d")
+// covering every website
+func rangeStart(timeRange string) time.Time {
+	loc, err := ReportLocation("")
+	if err != nil {
+		fmt.Printf("DB Error (time zone): %v\n", err)
 	}
+	q, _ := RangeQuery(timeRange, loc)
+	return q.From.UTC()
+}
 
-	query := fmt.Sprintf(`
-		SELECT %s, COUNT(*) as count 
-		FROM events 
-		WHERE %s != ''
-		GROUP BY %s 
-		ORDER BY count DESC 
-		LIMIT ?
-	`, column, column, column)
+// importedDimensions are the dimensions that imported aggregates add to
+var importedDimensions = map[string]string{
+	"page":    models.ImportedPages,
+	"source":  models.ImportedSources,
+	"country": models.ImportedCountries,
+}
 
-	rows, err := DB.Query(query, limit)
+// GetTopStats counts the query's events per value of a dimension (a key of Dimensions),
+// from the rollups when the query has no filters. Rows of the "page" dimension carry
+// the latest known title of the page.
+func GetTopStats(q Query, dimension string, limit int) ([]models.TableRow, error) {
+	// Only known dimensions reach the SQL, to prevent injection
+	if _, ok := Dimensions[dimension]; !ok {
+		return nil, fmt.Errorf("unknown dimension %q", dimension)
+	}
+	counts, args, err := countsSQL(q, dimension, "1 = 1", []string{"SUM(views)"}, []string{"COUNT(*)"})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var stats []models.TableRow
-	for rows.Next() {
-		var row models.TableRow
-		if err := rows.Scan(&row.Key, &row.Value); err != nil {
-			continue
-		}
-		stats = append(stats, row)
+	native := "SELECT key, c0 as count FROM (" + counts + ")"
+	if imported, ok := importedDimensions[dimension]; ok {
+		native, args = mergeImported(native, args, imported, q)
 	}
-	return stats, nil
-}
 
-// GetTopSources aggregates referrers, treating empty strings as "Direct"
-func GetTopSources(limit int) ([]models.TableRow, error) {
-	// SQLite CASE WHEN to handle empty referrer
-	query := `
-		SELECT 
-			CASE WHEN referrer = '' THEN 'Direct' ELSE referrer END as source, 
-			COUNT(*) as count 
-		FROM events 
-		GROUP BY source 
+	// The title lookup follows the counts so that its argument comes after theirs
+	title := "''"
+	if dimension == "page" {
+		title = pageTitleSQL("stats.key")
+		args = append(args, q.WebsiteID)
+	}
+	rows, err := DB.Query(`
+		WITH stats AS (`+native+`)
+		SELECT key, count, `+title+` as title
+		FROM stats
 		ORDER BY count DESC 
 		LIMIT ?
-	`
-	rows, err := DB.Query(query, limit)
+	`, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -236,7 +300,7 @@ func GetTopSources(limit int) ([]models.TableRow, error) {
 	var stats []models.TableRow
 	for rows.Next() {
 		var row models.TableRow
-		if err := rows.Scan(&row.Key, &row.Value); err != nil {
+		if err := rows.Scan(&row.Key, &row.Value, &row.Title); err != nil {
 			continue
 		}
 		stats = append(stats, row)
@@ -244,14 +308,40 @@ func GetTopSources(limit int) ([]models.TableRow, error) {
 	return stats, nil
 }
 
-// GetRecentEvents retrieves the latest N events
-func GetRecentEvents(limit int) ([]models.Event, error) {
+// pageTitleSQL looks up the latest known title of the page in column. It takes the query's
+// website ID as argument; with no website, the latest title of any website is used.
+func pageTitleSQL(column string) string {
+	return `COALESCE((SELECT title FROM page_titles pt
+		WHERE pt.path = ` + column + ` AND pt.website_id = COALESCE(NULLIF(?, ''), pt.website_id)
+		ORDER BY updated_at DESC LIMIT 1), '')`
+}
+
+// CountVisitors counts the distinct human visitors of the query
+func CountVisitors(q Query) (int, error) {
+	where, args, err := q.where()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = DB.QueryRow(`SELECT COUNT(DISTINCT visitor_id) FROM events WHERE `+where+` AND is_bot = 0`, args...).Scan(&n)
+	return n, err
+}
+
+// GetRecentEvents retrieves the latest N events of the query
+func GetRecentEvents(q Query, limit int) ([]models.Event, error) {
+	where, args, err := q.where()
+	if err != nil {
+		return nil, err
+	}
 	rows, err := DB.Query(`
-		SELECT timestamp, country, current_url, referrer, keyword, os, browser, screen_resolution, device, ip_hash, is_bot 
+		SELECT website_id, timestamp, visitor_id, country, country_code, current_url, referrer, keyword, os, browser,
+			screen_resolution, device, ip_hash, user_agent, is_bot, source_type, page_title, normalized_path,
+			hostname, path, query_string, referrer_host, referrer_path, language, locale
 		FROM events 
+		WHERE `+where+`
 		ORDER BY timestamp DESC 
 		LIMIT ?
-	`, limit)
+	`, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -260,21 +350,45 @@ func GetRecentEvents(limit int) ([]models.Event, error) {
 	var events []models.Event
 	for rows.Next() {
 		var e models.Event
-		// Handle potentially nullable fields if schema wasn't strict, but here we defined them as text.
-		// SQLite might return null for empty strings if not careful, but our insert logic uses empty strings.
+		var websiteID sql.NullString
+		// Columns added by migrations default to '', only the original ones may be NULL
 		if err := rows.Scan(
-			&e.Timestamp, &e.Country, &e.CurrentURL, &e.Referrer, &e.Keyword,
-			&e.OS, &e.Browser, &e.ScreenResolution, &e.Device, &e.IPHash, &e.IsBot,
+			&websiteID, &e.Timestamp, &e.VisitorID, &e.Country, &e.CountryCode, &e.CurrentURL, &e.Referrer, &e.Keyword,
+			&e.OS, &e.Browser, &e.ScreenResolution, &e.Device, &e.IPHash, &e.UserAgent, &e.IsBot,
+			&e.SourceType, &e.PageTitle, &e.NormalizedPath,
+			&e.Hostname, &e.Path, &e.QueryString, &e.ReferrerHost, &e.ReferrerPath, &e.Language, &e.Locale,
 		); err != nil {
 			return nil, err
 		}
+		e.WebsiteID = websiteID.String
 		events = append(events, e)
 	}
 	return events, nil
 }
 
+// websiteColumns is the column list read by scanWebsite
+const websiteColumns = `id, name, url, created_at, identity_mode,
+	strip_params, strip_all_params, allow_params, fold_trailing_slash, fold_case, hash_routes, api_key, timezone`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebsite(row rowScanner) (models.Website, error) {
+	var w models.Website
+	var stripParams, allowParams string
+	rules := &w.Normalization
+	err := row.Scan(&w.ID, &w.Name, &w.URL, &w.CreatedAt, &w.IdentityMode,
+		&stripParams, &rules.StripAllParams, &allowParams, &rules.FoldTrailingSlash, &rules.FoldCase, &rules.HashRoutes,
+		&w.APIKey, &w.TimeZone)
+	rules.StripParams = urlnorm.ParseList(stripParams)
+	rules.AllowParams = urlnorm.ParseList(allowParams)
+	return w, err
+}
+
 func GetWebsites() ([]models.Website, error) {
-	rows, err := DB.Query("SELECT id, name, url, created_at FROM websites ORDER BY created_at DESC")
+	rows, err := DB.Query("SELECT " + websiteColumns + " FROM websites ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -282,8 +396,8 @@ func GetWebsites() ([]models.Website, error) {
 
 	var websites []models.Website
 	for rows.Next() {
-		var w models.Website
-		if err := rows.Scan(&w.ID, &w.Name, &w.URL, &w.CreatedAt); err != nil {
+		w, err := scanWebsite(rows)
+		if err != nil {
 			return nil, err
 		}
 		websites = append(websites, w)
@@ -291,6 +405,15 @@ func GetWebsites() ([]models.Website, error) {
 	return websites, nil
 }
 
+// GetWebsite returns a single website by ID; ok is false if it does not exist
+func GetWebsite(id string) (w models.Website, ok bool, err error) {
+	w, err = scanWebsite(DB.QueryRow("SELECT "+websiteColumns+" FROM websites WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return w, false, nil
+	}
+	return w, err == nil, err
+}
+
 func AddWebsite(name, url string) error {
 	// Generate a simple ID like before
 	id := fmt.Sprintf("SITE_%d", time.Now().Unix())
@@ -299,7 +422,7 @@ func AddWebsite(name, url string) error {
 	if err != nil {
 		return err
 	}
-	_, err = statement.Exec(id, name, url, time.Now())
+	_, err = statement.Exec(id, name, url, time.Now().UTC())
 	return err
 }
 
@@ -311,3 +434,78 @@ func DeleteWebsite(id string) error {
 	_, err = statement.Exec(id)
 	return err
 }
+
+// GetSetting returns the value stored for an application setting, or "" if unset
+func GetSetting(key string) (string, error) {
+	var value string
+	err := DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetSetting stores an application setting, replacing any previous value
+func SetSetting(key, value string) error {
+	_, err := DB.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+// SetWebsiteIdentityMode switches how visitors of a website are identified
+func SetWebsiteIdentityMode(id, mode string) error {
+	_, err := DB.Exec("UPDATE websites SET identity_mode = ? WHERE id = ?", mode, id)
+	return err
+}
+
+// GetWebsiteByAPIKey returns the website authenticated by a server-side tracking key
+func GetWebsiteByAPIKey(key string) (w models.Website, ok bool, err error) {
+	if key == "" {
+		return w, false, nil
+	}
+	w, err = scanWebsite(DB.QueryRow("SELECT "+websiteColumns+" FROM websites WHERE api_key = ?", key))
+	if err == sql.ErrNoRows {
+		return w, false, nil
+	}
+	return w, err == nil, err
+}
+
+// SetWebsiteAPIKey replaces the server-side tracking key of a website; an empty key revokes it
+func SetWebsiteAPIKey(id, key string) error {
+	_, err := DB.Exec("UPDATE websites SET api_key = ? WHERE id = ?", key, id)
+	return err
+}
+
+// SetWebsiteTimeZone sets the IANA time zone of a website's reports; "" uses the server's
+func SetWebsiteTimeZone(id, timeZone string) error {
+	_, err := DB.Exec("UPDATE websites SET timezone = ? WHERE id = ?", timeZone, id)
+	return err
+}
+
+// ReportLocation returns the time zone of a website's reports. Reports covering every
+// website use the zone they all share, or the server's when they differ.
+func ReportLocation(websiteID string) (*time.Location, error) {
+	if websiteID != "" {
+		w, _, err := GetWebsite(websiteID)
+		return w.Location(), err
+	}
+	websites, err := GetWebsites()
+	if err != nil || len(websites) == 0 {
+		return time.Local, err
+	}
+	for _, w := range websites[1:] {
+		if w.TimeZone != websites[0].TimeZone {
+			return time.Local, nil
+		}
+	}
+	return websites[0].Location(), nil
+}
+
+// SetWebsiteNormalization saves the URL normalization rules of a website.
+// They apply to events ingested from now on.
+func SetWebsiteNormalization(id string, rules models.NormalizationRules) error {
+	_, err := DB.Exec(`UPDATE websites SET strip_params = ?, strip_all_params = ?, allow_params = ?,
+		fold_trailing_slash = ?, fold_case = ?, hash_routes = ? WHERE id = ?`,
+		strings.Join(rules.StripParams, ","), rules.StripAllParams, strings.Join(rules.AllowParams, ","),
+		rules.FoldTrailingSlash, rules.FoldCase, rules.HashRoutes, id)
+	return err
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"gogol_analytics/models"
+	"sort"
+	"strings"
+)
+
+func insertJSErrors(tx *sql.Tx, errs []models.JSError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO js_errors (
+		website_id, timestamp, fingerprint, message, source, line, col, stack, path, browser, os
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range errs {
+		if _, err := stmt.Exec(e.WebsiteID, e.Timestamp.UTC(), e.Fingerprint, e.Message, e.Source,
+			e.Line, e.Column, e.Stack, e.Path, e.Browser, e.OS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errorGroupPages is the number of most affected pages listed per error group
+const errorGroupPages = 5
+
+// GetErrorGroups returns the most frequent JavaScript errors of the time range grouped by fingerprint,
+// with the details of the latest occurrence in the range and the most affected pages
+func GetErrorGroups(timeRange string, limit int) ([]models.ErrorGroup, error) {
+	rows, err := DB.Query(`
+		WITH ranged AS (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY fingerprint ORDER BY timestamp DESC, id DESC) AS recent
+			FROM js_errors
+			WHERE timestamp >= ?
+		), groups AS (
+			SELECT fingerprint, COUNT(*) as count, COUNT(DISTINCT path) as pages,
+				GROUP_CONCAT(DISTINCT browser) as browsers
+			FROM ranged
+			GROUP BY fingerprint
+			ORDER BY count DESC
+			LIMIT ?
+		), top_pages AS (
+			SELECT fingerprint, path,
+				ROW_NUMBER() OVER (PARTITION BY fingerprint ORDER BY COUNT(*) DESC, path) AS page_rank
+			FROM ranged
+			WHERE fingerprint IN (SELECT fingerprint FROM groups)
+			GROUP BY fingerprint, path
+		)
+		SELECT g.fingerprint, g.count, g.pages, g.browsers,
+			l.message, l.source, l.line, l.col, l.stack, l.timestamp,
+			(SELECT GROUP_CONCAT(path, char(10)) FROM (
+				SELECT path FROM top_pages p
+				WHERE p.fingerprint = g.fingerprint AND page_rank <= ?
+				ORDER BY page_rank
+			))
+		FROM groups g
+		JOIN ranged l ON l.fingerprint = g.fingerprint AND l.recent = 1
+		ORDER BY g.count DESC, g.fingerprint
+	`, rangeStart(timeRange), limit, errorGroupPages)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.ErrorGroup
+	for rows.Next() {
+		var g models.ErrorGroup
+		var browsers, pages sql.NullString
+		if err := rows.Scan(&g.Fingerprint, &g.Count, &g.PageCount, &browsers,
+			&g.Message, &g.Source, &g.Line, &g.Column, &g.Stack, &g.LastSeen, &pages); err != nil {
+			return nil, err
+		}
+		g.Browsers = strings.Split(browsers.String, ",")
+		sort.Strings(g.Browsers)
+		g.Pages = strings.Split(pages.String, "\n")
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
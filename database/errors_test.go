@@ -0,0 +1,61 @@
+package database
+
+import (
+	"gogol_analytics/models"
+	"testing"
+	"time"
+)
+
+func TestErrorGroups(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	occurrence := func(fingerprint, message, path, browser string, age time.Duration) models.JSError {
+		return models.JSError{WebsiteID: "site", Timestamp: now.Add(-age), Fingerprint: fingerprint,
+			Message: message, Path: path, Browser: browser, Line: 1}
+	}
+	for _, e := range []models.JSError{
+		occurrence("a", "a: old message", "/", "Chrome", 2*time.Hour),
+		occurrence("a", "a: latest message", "/cart", "Firefox", time.Hour),
+		occurrence("a", "a: first message", "/cart", "Chrome", 3*time.Hour),
+		occurrence("b", "b: in range", "/", "Safari", time.Hour),
+		occurrence("b", "b: in range", "/", "Safari", 0),
+		// Before the 24h range: neither counted nor shown as the latest occurrence
+		occurrence("b", "b: out of range", "/old", "Edge", 48*time.Hour),
+		occurrence("b", "b: out of range", "/old", "Edge", 72*time.Hour),
+		occurrence("b", "b: out of range", "/old", "Edge", 96*time.Hour),
+		occurrence("c", "c: out of range only", "/", "Chrome", 48*time.Hour),
+	} {
+		if err := EnqueueJSError(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := GetErrorGroups("24h", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups in range, got %+v", groups)
+	}
+	a, b := groups[0], groups[1]
+	if a.Fingerprint != "a" || a.Count != 3 || a.PageCount != 2 || a.Message != "a: latest message" {
+		t.Errorf("unexpected group a: %+v", a)
+	}
+	if len(a.Pages) != 2 || a.Pages[0] != "/cart" || a.Pages[1] != "/" {
+		t.Errorf("expected the most affected page first, got %q", a.Pages)
+	}
+	if len(a.Browsers) != 2 || a.Browsers[0] != "Chrome" || a.Browsers[1] != "Firefox" {
+		t.Errorf("unexpected browsers %q", a.Browsers)
+	}
+	if b.Fingerprint != "b" || b.Count != 2 || b.PageCount != 1 || b.Message != "b: in range" || len(b.Pages) != 1 {
+		t.Errorf("unexpected group b: %+v", b)
+	}
+	if a.LastSeen.Sub(now.Add(-time.Hour)).Abs() > time.Second {
+		t.Errorf("unexpected last seen %v", a.LastSeen)
+	}
+
+	if groups, err := GetErrorGroups("24h", 1); err != nil || len(groups) != 1 || groups[0].Fingerprint != "a" {
+		t.Errorf("expected the limit to keep the most frequent group, got %+v (%v)", groups, err)
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"gogol_analytics/models"
+	"strings"
+	"time"
+)
+
+// ExportColumns lists the events columns available to raw exports, in their default order
+var ExportColumns = []models.ExportColumn{
+	{Name: "id", Type: models.ColumnInt},
+	{Name: "website_id", Type: models.ColumnString},
+	{Name: "timestamp", Type: models.ColumnTime},
+	{Name: "visitor_id", Type: models.ColumnString},
+	{Name: "source_type", Type: models.ColumnString},
+	{Name: "is_bot", Type: models.ColumnBool},
+	{Name: "current_url", Type: models.ColumnString},
+	{Name: "hostname", Type: models.ColumnString},
+	{Name: "path", Type: models.ColumnString},
+	{Name: "query_string", Type: models.ColumnString},
+	{Name: "normalized_path", Type: models.ColumnString},
+	{Name: "page_title", Type: models.ColumnString},
+	{Name: "referrer", Type: models.ColumnString},
+	{Name: "referrer_host", Type: models.ColumnString},
+	{Name: "referrer_path", Type: models.ColumnString},
+	{Name: "keyword", Type: models.ColumnString},
+	{Name: "country", Type: models.ColumnString},
+	{Name: "country_code", Type: models.ColumnString},
+	{Name: "language", Type: models.ColumnString},
+	{Name: "locale", Type: models.ColumnString},
+	{Name: "os", Type: models.ColumnString},
+	{Name: "browser", Type: models.ColumnString},
+	{Name: "device", Type: models.ColumnString},
+	{Name: "screen_resolution", Type: models.ColumnString},
+	{Name: "user_agent", Type: models.ColumnString},
+	{Name: "ip_hash", Type: models.ColumnString},
+}
+
+// ExportColumnsByName resolves a column selection; an empty selection means every column
+func ExportColumnsByName(names []string) ([]models.ExportColumn, error) {
+	if len(names) == 0 {
+		return ExportColumns, nil
+	}
+	columns := make([]models.ExportColumn, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, c := range ExportColumns {
+			if c.Name == name {
+				columns = append(columns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+	return columns, nil
+}
+
+// ExportEvents streams a website's events logged in [from, to) in insertion order,
+// calling fn with one value per column (string, int64, bool or time.Time).
+// Rows are read one at a time so exports of any size run in constant memory.
+func ExportEvents(websiteID string, from, to time.Time, columns []models.ExportColumn, fn func(values []any) error) error {
+	selected := make([]string, len(columns))
+	for i, c := range columns {
+		switch c.Type {
+		case models.ColumnString:
+			selected[i] = "COALESCE(" + c.Name + ", '')"
+		case models.ColumnBool:
+			selected[i] = "COALESCE(" + c.Name + ", 0)"
+		default:
+			// Keep the declared type so the driver returns timestamps as time.Time
+			selected[i] = c.Name
+		}
+	}
+
+	rows, err := DB.Query(`SELECT `+strings.Join(selected, ", ")+`
+		FROM events
+		WHERE website_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY id`, websiteID, from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	var (
+		strs  = make([]string, len(columns))
+		ints  = make([]int64, len(columns))
+		bools = make([]bool, len(columns))
+		times = make([]sql.NullTime, len(columns))
+	)
+	for i, c := range columns {
+		switch c.Type {
+		case models.ColumnInt:
+			dest[i] = &ints[i]
+		case models.ColumnBool:
+			dest[i] = &bools[i]
+		case models.ColumnTime:
+			dest[i] = &times[i]
+		default:
+			dest[i] = &strs[i]
+		}
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, c := range columns {
+			switch c.Type {
+			case models.ColumnInt:
+				values[i] = ints[i]
+			case models.ColumnBool:
+				values[i] = bools[i]
+			case models.ColumnTime:
+				values[i] = times[i].Time
+			default:
+				values[i] = strs[i]
+			}
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"gogol_analytics/models"
+)
+
+// SaveImportedStats stores imported aggregates in one transaction. Importing the
+// same export again replaces its numbers instead of adding them twice.
+func SaveImportedStats(stats []models.ImportedStat) error {
+	return inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO imported_stats (website_id, date, tool, dimension, key, visitors, pageviews)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (website_id, date, tool, dimension, key)
+			DO UPDATE SET visitors = excluded.visitors, pageviews = excluded.pageviews`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, s := range stats {
+			if _, err := stmt.Exec(s.WebsiteID, s.Date, s.Tool, s.Dimension, s.Key, s.Visitors, s.Pageviews); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// importedBeforeTracking restricts imported_stats rows to the days before the
+// website's first native event, so imported and tracked traffic never overlap
+const importedBeforeTracking = `imported_stats.date < COALESCE(
+	(SELECT date(MIN(e.timestamp)) FROM events e WHERE e.website_id = imported_stats.website_id), '9999-12-31')`
+
+// importedCount is what imported rows add to a table: page views when the export
+// has them, else visitors (source and country exports often only count visitors)
+const importedCount = `SUM(CASE WHEN pageviews > 0 THEN pageviews ELSE visitors END)`
+
+// mergeImported wraps a native "key, count" aggregation and its arguments so that
+// the query's imported aggregates of the dimension are added to it
+func mergeImported(native string, args []any, dimension string, q Query) (string, []any) {
+	where, importedArgs := q.importedWhere()
+	return fmt.Sprintf(`
+		SELECT key, SUM(count) as count FROM (
+			SELECT * FROM (%s)
+			UNION ALL
+			SELECT key, %s FROM imported_stats
+			WHERE dimension = '%s' AND %s
+			GROUP BY key
+		)
+		GROUP BY key`, native, importedCount, dimension, where), append(args, importedArgs...)
+}
+
+// importedDaily returns the query's imported daily totals, before tracking started
+func importedDaily(q Query) (map[string]models.ImportedStat, error) {
+	where, args := q.importedWhere()
+	rows, err := DB.Query(`
+		SELECT date, SUM(visitors), SUM(pageviews) FROM imported_stats
+		WHERE dimension = ? AND `+where+`
+		GROUP BY date
+	`, append([]any{models.ImportedVisitors}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := map[string]models.ImportedStat{}
+	for rows.Next() {
+		var s models.ImportedStat
+		if err := rows.Scan(&s.Date, &s.Visitors, &s.Pageviews); err != nil {
+			return nil, err
+		}
+		days[s.Date] = s
+	}
+	return days, rows.Err()
+}
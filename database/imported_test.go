@@ -0,0 +1,51 @@
+package database
+
+import (
+	"gogol_analytics/models"
+	"testing"
+	"time"
+)
+
+func TestImportedStatsMergeBeforeTracking(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	day := func(daysAgo int) string { return now.AddDate(0, 0, -daysAgo).Format("2006-01-02") }
+
+	// Tracking started 3 days ago
+	err := InsertEvents([]models.Event{
+		{WebsiteID: "site", Timestamp: now.AddDate(0, 0, -3), VisitorID: "v", NormalizedPath: "/blog"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SaveImportedStats([]models.ImportedStat{
+		{WebsiteID: "site", Date: day(5), Tool: "plausible", Dimension: models.ImportedVisitors, Visitors: 7, Pageviews: 20},
+		{WebsiteID: "site", Date: day(2), Tool: "plausible", Dimension: models.ImportedVisitors, Visitors: 9, Pageviews: 30},
+		{WebsiteID: "site", Date: day(5), Tool: "plausible", Dimension: models.ImportedPages, Key: "/blog", Pageviews: 4},
+		{WebsiteID: "site", Date: day(1), Tool: "plausible", Dimension: models.ImportedPages, Key: "/blog", Pageviews: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := GetTopStats(Query{}, "page", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Key != "/blog" || pages[0].Value != 5 {
+		t.Errorf("expected 1 tracked + 4 imported views of /blog, got %+v", pages)
+	}
+
+	points, err := GetChartData(RangeQuery("7d", time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := points[len(points)-1-5]; p.ImportedVisitors != 7 || p.Views != 20 {
+		t.Errorf("expected imported day before tracking in the chart, got %+v", p)
+	}
+	if p := points[len(points)-1-2]; p.ImportedVisitors != 0 {
+		t.Errorf("imported day after tracking started must be ignored, got %+v", p)
+	}
+}
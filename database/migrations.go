@@ -0,0 +1,501 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"gogol_analytics/models"
+	"gogol_analytics/urlnorm"
+	"log"
+)
+
+// migrate brings databases created by older versions up to the current schema.
+// Each step must be safe to run on every start.
+func migrate() {
+	// Tracking method (js, noscript, server) instead of flagging noscript hits as bots
+	added, err := addColumn("events", "source_type", "TEXT NOT NULL DEFAULT 'js'")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if added {
+		// Noscript hits used to be stored as bots with unknown country and resolution
+		if err := backfillNoscriptHits(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if _, err := addColumn("events", "page_title", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Visitor identity per website: IP+UA hash (default) or first-party cookie ID
+	if _, err := addColumn("websites", "identity_mode", "TEXT NOT NULL DEFAULT 'hash'"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Per-website URL normalization rules (defaults match models.DefaultNormalizationRules)
+	websiteRuleColumns := []struct{ name, definition string }{
+		{"strip_params", "TEXT NOT NULL DEFAULT ''"},
+		{"strip_all_params", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"allow_params", "TEXT NOT NULL DEFAULT ''"},
+		{"fold_trailing_slash", "BOOLEAN NOT NULL DEFAULT 1"},
+		{"fold_case", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"hash_routes", "BOOLEAN NOT NULL DEFAULT 0"},
+	}
+	for _, c := range websiteRuleColumns {
+		if _, err := addColumn("websites", c.name, c.definition); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Server-side tracking key. Added with the other website columns since the
+	// backfills below read websites through scanWebsite.
+	if _, err := addColumn("websites", "api_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_websites_api_key ON websites (api_key) WHERE api_key != ''"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Time zone of each website's reports. Timestamps were stored with the offset of
+	// the server's zone until then; they are converted to UTC at the end of migrate.
+	addedTimeZone, err := addColumn("websites", "timezone", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Page identity after normalization, computed at ingestion
+	added, err = addColumn("events", "normalized_path", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if added {
+		if err := backfillNormalizedPaths(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// URL parts as first-class columns so reports group on hosts and paths, not full URLs
+	urlPartColumns := []string{"hostname", "path", "query_string", "referrer_host", "referrer_path"}
+	addedParts := false
+	for _, column := range urlPartColumns {
+		added, err := addColumn("events", column, "TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			log.Fatal(err)
+		}
+		addedParts = addedParts || added
+	}
+	if addedParts {
+		if err := backfillURLParts(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_events_path ON events (website_id, path)"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Latest title of each page, keyed by normalized path
+	exists, err := tableExists("page_titles")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !exists {
+		if _, err := DB.Exec(`CREATE TABLE page_titles (
+			website_id TEXT NOT NULL,
+			path TEXT NOT NULL,
+			title TEXT NOT NULL,
+			updated_at DATETIME,
+			PRIMARY KEY (website_id, path)
+		)`); err != nil {
+			log.Fatal(err)
+		}
+		// Seed from titles already recorded by the noscript pixel (SQLite returns the
+		// title of the row holding MAX(timestamp))
+		if _, err := DB.Exec(`INSERT OR IGNORE INTO page_titles (website_id, path, title, updated_at)
+			SELECT COALESCE(website_id, ''), normalized_path, page_title, MAX(timestamp)
+			FROM events WHERE page_title != '' AND normalized_path != ''
+			GROUP BY website_id, normalized_path`); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Core Web Vitals samples, one row per page view that reported metrics
+	if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS web_vitals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		website_id TEXT,
+		timestamp DATETIME,
+		path TEXT,
+		device TEXT,
+		browser TEXT,
+		lcp REAL,
+		cls REAL,
+		inp REAL,
+		fcp REAL,
+		ttfb REAL
+	)`); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_web_vitals_timestamp ON web_vitals (timestamp)"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Client-side JavaScript errors reported by tracker.js
+	if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS js_errors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		website_id TEXT,
+		timestamp DATETIME,
+		fingerprint TEXT,
+		message TEXT,
+		source TEXT,
+		line INTEGER,
+		col INTEGER,
+		stack TEXT,
+		path TEXT,
+		browser TEXT,
+		os TEXT
+	)`); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_js_errors_fingerprint ON js_errors (fingerprint, timestamp)"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_js_errors_timestamp ON js_errors (timestamp)"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Visitor language ("fr") and locale ("fr-CA") from navigator.language or Accept-Language
+	for _, column := range []string{"language", "locale"} {
+		if _, err := addColumn("events", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Custom events reported through the server-side tracking API
+	if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS custom_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		website_id TEXT,
+		timestamp DATETIME,
+		visitor_id TEXT,
+		name TEXT,
+		path TEXT,
+		props TEXT
+	)`); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_custom_events_timestamp ON custom_events (timestamp)"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Daily aggregates imported from other analytics tools, kept apart from events
+	if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS imported_stats (
+		website_id TEXT NOT NULL,
+		date TEXT NOT NULL,
+		tool TEXT NOT NULL,
+		dimension TEXT NOT NULL,
+		key TEXT NOT NULL DEFAULT '',
+		visitors INTEGER NOT NULL DEFAULT 0,
+		pageviews INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (website_id, date, tool, dimension, key)
+	)`); err != nil {
+		log.Fatal(err)
+	}
+
+	// Time ranges of one website or of every website. is_bot, visitor_id and
+	// website_id let chart queries read the index alone instead of each event row.
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_events_site_timestamp ON events (website_id, timestamp, is_bot, visitor_id)"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events (timestamp, is_bot, visitor_id, website_id)"); err != nil {
+		log.Fatal(err)
+	}
+
+	if addedTimeZone {
+		if err := convertTimestampsToUTC(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// First human view of each visitor per website, kept up to date by insertEvents.
+	// Created after the UTC conversion so that the backfill copies UTC timestamps.
+	exists, err = tableExists("visitors")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !exists {
+		if _, err := DB.Exec(`CREATE TABLE visitors (
+			website_id TEXT NOT NULL,
+			visitor_id TEXT NOT NULL,
+			first_seen DATETIME NOT NULL,
+			PRIMARY KEY (website_id, visitor_id)
+		)`); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := DB.Exec(`INSERT INTO visitors (website_id, visitor_id, first_seen)
+			SELECT COALESCE(website_id, ''), visitor_id, MIN(timestamp)
+			FROM events WHERE is_bot = 0 AND visitor_id != ''
+			GROUP BY 1, 2`); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// New visitors of each chart bucket
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_visitors_first_seen ON visitors (first_seen, website_id, visitor_id)"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Session boundaries, kept at ingestion (see sessionWriter) so that entry and exit
+	// pages are plain aggregates. Backfilled after the UTC conversion.
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_events_sessions ON events (website_id, visitor_id, timestamp) WHERE " + sessionViews); err != nil {
+		log.Fatal(err)
+	}
+	addedEntry, err := addColumn("events", "session_entry", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	addedExit, err := addColumn("events", "session_exit", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if addedEntry || addedExit {
+		if err := backfillSessions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Daily and hourly counts per dimension, after the columns and flags they are built from
+	for _, r := range rollups {
+		exists, err := tableExists(r.table)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !exists {
+			if _, err := DB.Exec(r.createSQL()); err != nil {
+				log.Fatal(err)
+			}
+			if err := r.backfill(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// convertTimestampsToUTC rewrites timestamps stored with a zone offset as UTC, so
+// that they sort and compare as text. SQLite's strftime applies the offset.
+func convertTimestampsToUTC() error {
+	columns := []struct{ table, column string }{
+		{"events", "timestamp"},
+		{"page_titles", "updated_at"},
+		{"web_vitals", "timestamp"},
+		{"js_errors", "timestamp"},
+		{"custom_events", "timestamp"},
+	}
+	return inTx(func(tx *sql.Tx) error {
+		for _, c := range columns {
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%d %%H:%%M:%%f+00:00', %[2]s)
+				WHERE %[2]s IS NOT NULL AND %[2]s NOT LIKE '%%+00:00'`, c.table, c.column)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addColumn adds a column to a table unless it already exists and reports whether it was added
+func addColumn(table, column, definition string) (bool, error) {
+	exists, err := columnExists(table, column)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err == nil, err
+}
+
+func tableExists(table string) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	return n > 0, err
+}
+
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue any
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// backfillNoscriptHits marks the noscript hits of older versions, which stored them as bots
+func backfillNoscriptHits() error {
+	if _, err := DB.Exec(`UPDATE events SET source_type = 'noscript'
+		WHERE is_bot = 1 AND screen_resolution = 'unknown' AND country_code = 'unknown'`); err != nil {
+		return err
+	}
+
+	// They stay bots only if their User-Agent says so, like new hits
+	rows, err := DB.Query("SELECT id, user_agent FROM events WHERE source_type = 'noscript' AND is_bot = 1")
+	if err != nil {
+		return err
+	}
+	var humans []int64
+	for rows.Next() {
+		var (
+			id        int64
+			userAgent sql.NullString
+		)
+		if err := rows.Scan(&id, &userAgent); err != nil {
+			rows.Close()
+			return err
+		}
+		if !models.IsBotUserAgent(userAgent.String) {
+			humans = append(humans, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("UPDATE events SET is_bot = 0 WHERE id = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, id := range humans {
+		if _, err := stmt.Exec(id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// backfillNormalizedPaths computes normalized_path for events stored before the column existed
+func backfillNormalizedPaths() error {
+	websites, err := GetWebsites()
+	if err != nil {
+		return err
+	}
+	rulesBySite := make(map[string]models.NormalizationRules)
+	for _, w := range websites {
+		rulesBySite[w.ID] = w.Normalization
+	}
+
+	rows, err := DB.Query("SELECT id, website_id, current_url FROM events")
+	if err != nil {
+		return err
+	}
+	type update struct {
+		id   int64
+		path string
+	}
+	var updates []update
+	for rows.Next() {
+		var (
+			id         int64
+			websiteID  sql.NullString
+			currentURL sql.NullString
+		)
+		if err := rows.Scan(&id, &websiteID, &currentURL); err != nil {
+			rows.Close()
+			return err
+		}
+		rules, ok := rulesBySite[websiteID.String]
+		if !ok {
+			rules = models.DefaultNormalizationRules
+		}
+		updates = append(updates, update{id, urlnorm.NormalizedPath(currentURL.String, rules)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("UPDATE events SET normalized_path = ? WHERE id = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range updates {
+		if _, err := stmt.Exec(u.path, u.id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// backfillURLParts splits the URLs of events stored before the URL part columns existed
+func backfillURLParts() error {
+	rows, err := DB.Query("SELECT id, current_url, referrer FROM events")
+	if err != nil {
+		return err
+	}
+	type update struct {
+		id                                  int64
+		host, path, query, refHost, refPath string
+	}
+	var updates []update
+	for rows.Next() {
+		var (
+			id                   int64
+			currentURL, referrer sql.NullString
+		)
+		if err := rows.Scan(&id, &currentURL, &referrer); err != nil {
+			rows.Close()
+			return err
+		}
+		u := update{id: id}
+		u.host, u.path, u.query = urlnorm.Split(currentURL.String)
+		u.refHost, u.refPath, _ = urlnorm.Split(referrer.String)
+		updates = append(updates, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE events SET hostname = ?, path = ?, query_string = ?,
+		referrer_host = ?, referrer_path = ? WHERE id = ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range updates {
+		if _, err := stmt.Exec(u.host, u.path, u.query, u.refHost, u.refPath, u.id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"gogol_analytics/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSourceTypeMigration(t *testing.T) {
+	// Database written by a version without source_type, where noscript hits were bots
+	path := filepath.Join(t.TempDir(), "gogol_old.db")
+	old, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, website_id TEXT, timestamp DATETIME, visitor_id TEXT,
+		country TEXT, country_code TEXT, ip_hash TEXT, user_agent TEXT, screen_resolution TEXT,
+		referrer TEXT, current_url TEXT, is_bot BOOLEAN, os TEXT, browser TEXT, device TEXT, keyword TEXT
+	);
+	INSERT INTO events (website_id, timestamp, visitor_id, user_agent, country_code, screen_resolution, is_bot) VALUES
+		('site', '2024-01-01 10:00:00+00:00', 'a', 'Mozilla/5.0 Firefox/121.0', 'FR', '1920x1080', 0),
+		('site', '2024-01-01 10:00:00+00:00', 'b', 'Mozilla/5.0 Firefox/121.0', 'unknown', 'unknown', 1),
+		('site', '2024-01-01 10:00:00+00:00', 'c', 'Mozilla/5.0 Googlebot/2.1', 'US', '1920x1080', 1),
+		('site', '2024-01-01 10:00:00+00:00', 'd', 'Mozilla/5.0 (compatible; bingbot/2.0)', 'unknown', 'unknown', 1),
+		('site', '2024-01-01 10:00:00+00:00', 'e', NULL, 'unknown', 'unknown', 1);`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("DB_PATH", path)
+	InitDB()
+	t.Cleanup(func() { DB.Close() })
+
+	rows, err := DB.Query("SELECT visitor_id, source_type, is_bot FROM events ORDER BY visitor_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	// Noscript hits stay bots only when their User-Agent is a crawler's
+	want := map[string]struct {
+		sourceType string
+		isBot      bool
+	}{
+		"a": {models.SourceJS, false},
+		"b": {models.SourceNoscript, false},
+		"c": {models.SourceJS, true},
+		"d": {models.SourceNoscript, true},
+		"e": {models.SourceNoscript, false},
+	}
+	for rows.Next() {
+		var visitor, sourceType string
+		var isBot bool
+		if err := rows.Scan(&visitor, &sourceType, &isBot); err != nil {
+			t.Fatal(err)
+		}
+		if w := want[visitor]; sourceType != w.sourceType || isBot != w.isBot {
+			t.Errorf("%s: got %s (bot %v), want %s (bot %v)", visitor, sourceType, isBot, w.sourceType, w.isBot)
+		}
+	}
+}
+
+func TestSessionBackfill(t *testing.T) {
+	// Database written by a version without session flags
+	path := filepath.Join(t.TempDir(), "gogol_old.db")
+	old, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, website_id TEXT, timestamp DATETIME, visitor_id TEXT,
+		country TEXT, country_code TEXT, ip_hash TEXT, user_agent TEXT, screen_resolution TEXT,
+		referrer TEXT, current_url TEXT, is_bot BOOLEAN, os TEXT, browser TEXT, device TEXT, keyword TEXT
+	);
+	INSERT INTO events (website_id, timestamp, visitor_id, current_url, is_bot) VALUES
+		('site', '2024-01-01 10:00:00+00:00', 'a', 'https://example.com/1', 0),
+		('site', '2024-01-01 10:10:00+00:00', 'a', 'https://example.com/2', 0),
+		('site', '2024-01-01 11:00:00+00:00', 'a', 'https://example.com/3', 0),
+		('other', '2024-01-01 10:05:00+00:00', 'a', 'https://example.org/4', 0),
+		('site', '2024-01-01 10:05:00+00:00', 'bot', 'https://example.com/1', 1);`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("DB_PATH", path)
+	InitDB()
+	t.Cleanup(func() { DB.Close() })
+
+	want := map[string][2]bool{
+		"a /1":   {true, false},
+		"a /2":   {false, true},
+		"a /3":   {true, true},
+		"a /4":   {true, true},
+		"bot /1": {false, false},
+	}
+	got := sessionFlags(t)
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("%s: entry, exit = %v, want %v", key, got[key], w)
+		}
+	}
+}
+
+func TestSourceTypeDefaultsToJS(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	if err := InsertEvents([]models.Event{
+		{WebsiteID: "site", Timestamp: now, VisitorID: "a"},
+		{WebsiteID: "site", Timestamp: now.Add(time.Second), VisitorID: "b", SourceType: models.SourceServer},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := GetRecentEvents(Query{WebsiteID: "site"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, e := range events {
+		got[e.VisitorID] = e.SourceType
+	}
+	if got["a"] != models.SourceJS || got["b"] != models.SourceServer {
+		t.Errorf("unexpected source types %v", got)
+	}
+}
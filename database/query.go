@@ -0,0 +1,343 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"gogol_analytics/models"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Query selects the events a statistic is computed on. The zero Query covers every
+// website and all time.
+type Query struct {
+	WebsiteID string          // Empty for every website
+	From      time.Time       // Zero for since the first event
+	To        time.Time       // Exclusive; zero for until now
+	Filters   []models.Filter // Every filter must match
+	Location  *time.Location  // Time zone of days and chart buckets; nil for the server's
+	Scan      bool            // Aggregations over event columns scan the table; see Plan
+}
+
+// location returns the time zone days of the query are counted in
+func (q Query) location() *time.Location {
+	if q.Location == nil {
+		return time.Local
+	}
+	return q.Location
+}
+
+// Dimensions maps the dimension names used by tables, filters and the JSON API to
+// their SQL expression on the events table
+var Dimensions = map[string]string{
+	"page":          "normalized_path",
+	"hostname":      "hostname",
+	"source":        "CASE WHEN referrer_host = '' THEN 'Direct' ELSE referrer_host END",
+	"referrer":      "referrer_host",
+	"referrer_path": "referrer_path",
+	"keyword":       "keyword",
+	"country":       "country",
+	"language":      "language",
+	"locale":        "locale",
+	"os":            "os",
+	"browser":       "browser",
+	"device":        "device",
+	"screen":        "screen_resolution",
+	"source_type":   "source_type",
+}
+
+// RangePresets lists the named time ranges of the dashboard and the API, besides "custom"
+var RangePresets = []string{"24h", "7d", "30d", "month", "last_month", "ytd", "all"}
+
+// RangeQuery returns the query of a dashboard time range in a time zone and the chart
+// interval that goes with it. Ranges end now and start on a whole hour ("24h") or at
+// midnight ("7d" and "30d" include today), so chart buckets follow the clock.
+func RangeQuery(timeRange string, loc *time.Location) (Query, string) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	q := Query{To: now, Location: loc}
+	switch timeRange {
+	case "24h":
+		hour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, loc)
+		q.From = hour.Add(-23 * time.Hour)
+		return q, "hour"
+	case "7d":
+		q.From = today.AddDate(0, 0, -6)
+	case "month":
+		q.From = today.AddDate(0, 0, 1-today.Day())
+	case "last_month":
+		q.To = today.AddDate(0, 0, 1-today.Day())
+		q.From = q.To.AddDate(0, -1, 0)
+	case "ytd":
+		q.From = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, loc)
+	case "all":
+		var first sql.NullString
+		DB.QueryRow("SELECT MIN(timestamp) FROM events").Scan(&first)
+		q.From = today.AddDate(0, 0, -29)
+		if t, ok := parseTimestamp(first.String); ok && t.Before(q.From) {
+			t = t.In(loc)
+			q.From = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+	default:
+		q.From = today.AddDate(0, 0, -29)
+	}
+	return q, chartInterval(q)
+}
+
+// ResolveRange returns the query and chart interval of a preset of RangePresets or,
+// for "custom", of the inclusive days from and to (YYYY-MM-DD) in loc
+func ResolveRange(timeRange, from, to string, loc *time.Location) (Query, string, error) {
+	if timeRange != "custom" {
+		for _, preset := range RangePresets {
+			if timeRange == preset {
+				q, interval := RangeQuery(timeRange, loc)
+				return q, interval, nil
+			}
+		}
+		return Query{}, "", fmt.Errorf("unknown range %q", timeRange)
+	}
+
+	q := Query{Location: loc}
+	start, err := time.ParseInLocation("2006-01-02", from, loc)
+	if err != nil {
+		return q, "", fmt.Errorf("invalid from date %q", from)
+	}
+	end, err := time.ParseInLocation("2006-01-02", to, loc)
+	if err != nil {
+		return q, "", fmt.Errorf("invalid to date %q", to)
+	}
+	if end.Before(start) {
+		return q, "", fmt.Errorf("to is before from")
+	}
+	q.From, q.To = start, end.AddDate(0, 0, 1)
+	return q, chartInterval(q), nil
+}
+
+// parseTimestamp reads a timestamp returned by an SQL expression, which the driver
+// leaves as text
+func parseTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// chartInterval buckets ranges of up to two days by hour, longer ones by day
+func chartInterval(q Query) string {
+	if q.To.Sub(q.From) <= 48*time.Hour {
+		return "hour"
+	}
+	return "day"
+}
+
+// Comparison periods
+const (
+	ComparePrevious = "previous" // The same duration just before the range
+	CompareYear     = "year"     // The same dates one year earlier
+)
+
+// Compare returns the period a query is compared to. Ranges starting at midnight are
+// shifted by whole days, so the previous period also starts at midnight across DST changes.
+func (q Query) Compare(period string) (Query, error) {
+	prev := q
+	switch period {
+	case ComparePrevious:
+		from := q.From.In(q.location())
+		if from.Hour() == 0 && from.Minute() == 0 && from.Second() == 0 {
+			days := daysBetween(from, q.To.In(q.location()).Add(-time.Nanosecond)) + 1
+			prev.From, prev.To = from.AddDate(0, 0, -days), q.To.In(q.location()).AddDate(0, 0, -days)
+			break
+		}
+		length := q.To.Sub(q.From)
+		prev.From, prev.To = q.From.Add(-length), q.From
+	case CompareYear:
+		prev.From, prev.To = q.From.AddDate(-1, 0, 0), q.To.AddDate(-1, 0, 0)
+	default:
+		return prev, fmt.Errorf("unknown comparison %q", period)
+	}
+	return prev, nil
+}
+
+// daysBetween counts the calendar days from the date of a to the date of b
+func daysBetween(a, b time.Time) int {
+	dayA := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	dayB := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(dayB.Sub(dayA) / (24 * time.Hour))
+}
+
+// scanShare is the share of a website's event history above which aggregations reading
+// event columns scan the table: following the timestamp indexes costs one row lookup
+// per event, which is slower than a scan once the range covers much of the table
+const scanShare = 0.25
+
+// where returns the SQL condition selecting the query's events and its arguments
+func (q Query) where() (string, []any, error) {
+	return q.conditions("timestamp")
+}
+
+// Plan sets q.Scan when the range covers more than scanShare of the time since the
+// website's first event (every website's without WebsiteID). Pages call it once and
+// pass the result to each of their queries.
+func (q Query) Plan() Query {
+	q.Scan = true
+	if q.From.IsZero() {
+		return q
+	}
+	var first sql.NullString
+	if q.WebsiteID != "" {
+		DB.QueryRow("SELECT MIN(timestamp) FROM events WHERE website_id = ?", q.WebsiteID).Scan(&first)
+	} else {
+		DB.QueryRow("SELECT MIN(timestamp) FROM events").Scan(&first)
+	}
+	start, ok := parseTimestamp(first.String)
+	if !ok {
+		q.Scan = false
+		return q
+	}
+	to := q.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	q.Scan = float64(to.Sub(q.From)) > scanShare*float64(time.Since(start))
+	return q
+}
+
+// eventsTable is the table aggregations over event columns the indexes do not cover
+// read from: events, or with q.Scan the whole table without its indexes
+func (q Query) eventsTable() string {
+	if q.Scan {
+		return "events NOT INDEXED"
+	}
+	return "events"
+}
+
+// conditions builds where, matching the time range on the timestamp expression
+func (q Query) conditions(timestamp string) (string, []any, error) {
+	conditions := []string{"1 = 1"}
+	var args []any
+	if q.WebsiteID != "" {
+		conditions = append(conditions, "website_id = ?")
+		args = append(args, q.WebsiteID)
+	}
+	if !q.From.IsZero() {
+		conditions = append(conditions, timestamp+" >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, timestamp+" < ?")
+		args = append(args, q.To.UTC())
+	}
+	if len(q.Filters) > 0 {
+		filters, filterArgs, err := q.filterConditions()
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, filters)
+		args = append(args, filterArgs...)
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// filterConditions returns the SQL condition of the query's dimension filters alone
+// and its arguments ("1 = 1" without filters)
+func (q Query) filterConditions() (string, []any, error) {
+	conditions := []string{"1 = 1"}
+	var args []any
+	for _, f := range q.Filters {
+		expr, ok := Dimensions[f.Dimension]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown dimension %q", f.Dimension)
+		}
+		switch f.Op {
+		case models.FilterEquals:
+			conditions = append(conditions, expr+" = ?")
+		case models.FilterNotEquals:
+			conditions = append(conditions, "COALESCE("+expr+", '') != ?")
+		case models.FilterContains:
+			conditions = append(conditions, "instr(LOWER(COALESCE("+expr+", '')), LOWER(?)) > 0")
+		case models.FilterRegex:
+			conditions = append(conditions, "COALESCE("+expr+", '') REGEXP ?")
+		default:
+			return "", nil, fmt.Errorf("unknown filter operator %q", f.Op)
+		}
+		args = append(args, f.Value)
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// importedWhere returns the condition selecting the imported_stats rows of the
+// query. Imported aggregates have no per-event dimensions, so filtered queries
+// leave them out.
+func (q Query) importedWhere() (string, []any) {
+	if len(q.Filters) > 0 {
+		return "0", nil
+	}
+	conditions := []string{importedBeforeTracking}
+	var args []any
+	if q.WebsiteID != "" {
+		conditions = append(conditions, "website_id = ?")
+		args = append(args, q.WebsiteID)
+	}
+	if !q.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, q.From.In(q.location()).Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, q.To.Add(-time.Nanosecond).In(q.location()).Format("2006-01-02"))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// ParseFilter reads a filter written as "dimension:op:value", or "dimension:value"
+// for equality
+func ParseFilter(raw string) (models.Filter, error) {
+	dimension, rest, ok := strings.Cut(raw, ":")
+	if _, known := Dimensions[dimension]; !ok || !known {
+		return models.Filter{}, fmt.Errorf("invalid filter %q", raw)
+	}
+	f := models.Filter{Dimension: dimension, Op: models.FilterEquals, Value: rest}
+	if op, value, ok := strings.Cut(rest, ":"); ok {
+		if _, known := models.FilterOps[op]; known {
+			f.Op, f.Value = op, value
+		}
+	}
+	if f.Op == models.FilterRegex {
+		if _, err := regexp.Compile(f.Value); err != nil {
+			return models.Filter{}, fmt.Errorf("invalid filter %q: %v", raw, err)
+		}
+	}
+	return f, nil
+}
+
+// regexps caches the patterns compiled by the SQL regexp() function
+var (
+	regexps      = map[string]*regexp.Regexp{}
+	regexpsMutex sync.Mutex
+)
+
+// sqlRegexp implements SQLite's "text REGEXP pattern" operator
+func sqlRegexp(pattern, text string) (bool, error) {
+	regexpsMutex.Lock()
+	re, ok := regexps[pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			regexpsMutex.Unlock()
+			return false, err
+		}
+		if len(regexps) >= 100 {
+			regexps = map[string]*regexp.Regexp{}
+		}
+		regexps[pattern] = re
+	}
+	regexpsMutex.Unlock()
+	return re.MatchString(text), nil
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"gogol_analytics/models"
+	"testing"
+	"time"
+)
+
+func TestQueryFilters(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	err := InsertEvents([]models.Event{
+		{WebsiteID: "site", Timestamp: now, VisitorID: "a", NormalizedPath: "/blog/go", Browser: "Firefox"},
+		{WebsiteID: "site", Timestamp: now, VisitorID: "b", NormalizedPath: "/blog/sql", Browser: "Chrome"},
+		{WebsiteID: "site", Timestamp: now, VisitorID: "c", NormalizedPath: "/pricing", Browser: "Chrome"},
+		{WebsiteID: "other", Timestamp: now, VisitorID: "d", NormalizedPath: "/blog/go", Browser: "Chrome"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filters []string
+		want    int
+	}{
+		{[]string{"browser:Chrome"}, 2},
+		{[]string{"browser:eq:Chrome", "page:neq:/pricing"}, 1},
+		{[]string{"page:contains:BLOG"}, 2},
+		{[]string{"page:regex:^/blog/(go|rust)$"}, 1},
+	}
+	for _, tt := range tests {
+		q := Query{WebsiteID: "site"}
+		for _, raw := range tt.filters {
+			f, err := ParseFilter(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.Filters = append(q.Filters, f)
+		}
+		rows, err := GetTopStats(q, "page", 10)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.filters, err)
+		}
+		if len(rows) != tt.want {
+			t.Errorf("%v: got %d pages, want %d (%+v)", tt.filters, len(rows), tt.want, rows)
+		}
+	}
+
+	for _, raw := range []string{"ip_hash:x", "page:regex:((", "nodimension"} {
+		if _, err := ParseFilter(raw); err == nil {
+			t.Errorf("ParseFilter(%q): expected an error", raw)
+		}
+	}
+}
+
+func TestResolveRangeAndCompare(t *testing.T) {
+	q, interval, err := ResolveRange("custom", "2024-03-01", "2024-03-31", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interval != "day" || q.To.Sub(q.From) != 31*24*time.Hour {
+		t.Errorf("custom range: %v – %v by %s", q.From, q.To, interval)
+	}
+
+	prev, _ := q.Compare(ComparePrevious)
+	if !prev.To.Equal(q.From) || prev.From.Format("2006-01-02") != "2024-01-30" {
+		t.Errorf("previous period: %v – %v", prev.From, prev.To)
+	}
+	year, _ := q.Compare(CompareYear)
+	if year.From.Format("2006-01-02") != "2023-03-01" || year.To.Format("2006-01-02") != "2023-04-01" {
+		t.Errorf("previous year: %v – %v", year.From, year.To)
+	}
+
+	if _, interval, _ := ResolveRange("custom", "2024-03-01", "2024-03-01", time.UTC); interval != "hour" {
+		t.Errorf("single day should be bucketed by hour, got %s", interval)
+	}
+	for _, bad := range [][3]string{{"custom", "2024-03-02", "2024-03-01"}, {"custom", "x", "2024-03-01"}, {"90d", "", ""}} {
+		if _, _, err := ResolveRange(bad[0], bad[1], bad[2], time.UTC); err == nil {
+			t.Errorf("ResolveRange%v: expected an error", bad)
+		}
+	}
+}
+
+func TestChartBucketsFollowTimeZone(t *testing.T) {
+	setupTestDB(t)
+
+	// New York switches to daylight saving time on 10 March 2024: that day lasts 23 hours
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data unavailable:", err)
+	}
+	err = InsertEvents([]models.Event{
+		{WebsiteID: "site", Timestamp: time.Date(2024, 3, 10, 23, 30, 0, 0, ny), VisitorID: "a"},
+		{WebsiteID: "site", Timestamp: time.Date(2024, 3, 11, 0, 30, 0, 0, ny), VisitorID: "b"},
+		{WebsiteID: "site", Timestamp: time.Date(2024, 3, 11, 4, 30, 0, 0, time.UTC), VisitorID: "c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, interval, err := ResolveRange("custom", "2024-03-09", "2024-03-11", ny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.WebsiteID = "site"
+	points, err := GetChartData(q, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("expected 3 daily buckets, got %d", len(points))
+	}
+	if want := time.Date(2024, 3, 11, 0, 0, 0, 0, ny); !points[2].Time.Equal(want) || points[2].Label != "Mon" {
+		t.Errorf("third bucket starts %v (%s), want %v", points[2].Time, points[2].Label, want)
+	}
+	if points[1].Views != 1 || points[2].Views != 2 {
+		t.Errorf("views per day: %d, %d, %d; want 0, 1, 2", points[0].Views, points[1].Views, points[2].Views)
+	}
+
+	// The same events by UTC day
+	q, _, _ = ResolveRange("custom", "2024-03-10", "2024-03-11", time.UTC)
+	q.WebsiteID = "site"
+	if points, err = GetChartData(q, "day"); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[1].Views != 3 {
+		t.Errorf("UTC buckets: %+v", points)
+	}
+}
+
+func TestPlanFollowsWebsiteHistory(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	err := InsertEvents([]models.Event{
+		{WebsiteID: "old", Timestamp: now.AddDate(0, 0, -100), VisitorID: "a"},
+		{WebsiteID: "new", Timestamp: now.AddDate(0, 0, -10), VisitorID: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	week := Query{From: now.AddDate(0, 0, -7)}
+	for website, scan := range map[string]bool{"": false, "old": false, "new": true, "none": false} {
+		q := week
+		q.WebsiteID = website
+		if got := q.Plan().Scan; got != scan {
+			t.Errorf("website %q: Scan = %v, want %v", website, got, scan)
+		}
+	}
+	if !(Query{}).Plan().Scan {
+		t.Error("expected all-time queries to scan")
+	}
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rollup is a table of event counts per UTC period (day or hour), website and value
+// of each dimension, kept up to date at ingestion so that tables over long ranges
+// read one row per period and value instead of every event. Rows of the "page"
+// dimension also count the page's session views, entries, exits and bounces.
+type rollup struct {
+	table  string
+	period time.Duration
+	format string // strftime format of the period start, like a stored timestamp
+}
+
+// rollups lists the rollup tables from the longest period to the shortest
+var rollups = []rollup{
+	{"daily_stats", 24 * time.Hour, "%Y-%m-%d 00:00:00+00:00"},
+	{"hourly_stats", time.Hour, "%Y-%m-%d %H:00:00+00:00"},
+}
+
+// createSQL returns the statement creating the rollup's table
+func (r rollup) createSQL() string {
+	return `CREATE TABLE ` + r.table + ` (
+		dimension TEXT NOT NULL,
+		start TEXT NOT NULL,
+		website_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		views INTEGER NOT NULL DEFAULT 0,
+		session_views INTEGER NOT NULL DEFAULT 0,
+		entries INTEGER NOT NULL DEFAULT 0,
+		exits INTEGER NOT NULL DEFAULT 0,
+		bounces INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (dimension, start, website_id, key)
+	) WITHOUT ROWID`
+}
+
+// startSQL returns the start of the period of a stored timestamp, formatted like one
+func (r rollup) startSQL(timestamp string) string {
+	return "strftime('" + r.format + "', " + timestamp + ")"
+}
+
+// rollupDimensions lists the keys of Dimensions in a stable order
+func rollupDimensions() []string {
+	names := make([]string, 0, len(Dimensions))
+	for name := range Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rollupWriter adds inserted events to the rollup tables
+type rollupWriter struct {
+	views, sessions []*sql.Stmt
+}
+
+func prepareRollupWriter(tx *sql.Tx) (*rollupWriter, error) {
+	w := &rollupWriter{}
+	for _, r := range rollups {
+		var selects []string
+		for _, name := range rollupDimensions() {
+			expr := Dimensions[name]
+			selects = append(selects, fmt.Sprintf(`SELECT '%s', %s, COALESCE(website_id, ''), %s, 1
+				FROM events WHERE id = ? AND %s != ''`, name, r.startSQL("timestamp"), expr, expr))
+		}
+		views, err := tx.Prepare(`INSERT INTO ` + r.table + ` (dimension, start, website_id, key, views)
+			` + strings.Join(selects, " UNION ALL ") + `
+			ON CONFLICT (dimension, start, website_id, key) DO UPDATE SET views = views + 1`)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.views = append(w.views, views)
+		sessions, err := tx.Prepare(`UPDATE ` + r.table + ` SET session_views = session_views + ?,
+			entries = entries + ?, exits = exits + ?, bounces = bounces + ?
+			WHERE dimension = 'page' AND start = ` + r.startSQL("?") + ` AND website_id = ? AND key = ?`)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.sessions = append(w.sessions, sessions)
+	}
+	return w, nil
+}
+
+func (w *rollupWriter) Close() {
+	for _, stmt := range append(w.views, w.sessions...) {
+		stmt.Close()
+	}
+}
+
+// add counts the inserted event with the given id once per dimension
+func (w *rollupWriter) add(id int64) error {
+	args := make([]any, len(Dimensions))
+	for i := range args {
+		args[i] = id
+	}
+	for _, stmt := range w.views {
+		if _, err := stmt.Exec(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSession adds to the session counts of a page view's periods; the page rows must exist
+func (w *rollupWriter) addSession(websiteID, path string, at time.Time, views, entries, exits, bounces int) error {
+	for _, stmt := range w.sessions {
+		if _, err := stmt.Exec(views, entries, exits, bounces, at.UTC(), websiteID, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfill fills the rollup's table from the events stored before it existed
+func (r rollup) backfill() error {
+	return inTx(func(tx *sql.Tx) error {
+		for _, name := range rollupDimensions() {
+			expr := Dimensions[name]
+			sessions := "0, 0, 0, 0"
+			if name == "page" {
+				sessions = "SUM(" + sessionViews + "), SUM(session_entry), SUM(session_exit), SUM(session_entry AND session_exit)"
+			}
+			if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s
+				SELECT '%s', %s AS period, COALESCE(website_id, '') AS site, %s AS key, COUNT(*), %s
+				FROM events WHERE %s != ''
+				GROUP BY period, site, key`, r.table, name, r.startSQL("timestamp"), expr, sessions, expr)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// span is a part of a query's time range read from a rollup, or from events when
+// rollup is nil. Zero times leave the span open like those of Query.
+type span struct {
+	rollup   *rollup
+	from, to time.Time
+}
+
+// rollupSpans splits the range from from to to into the whole periods of the first
+// rollup and, at its edges, the spans of the next ones, down to events
+func rollupSpans(from, to time.Time, rollups []rollup) []span {
+	if len(rollups) == 0 {
+		return []span{{nil, from, to}}
+	}
+	r := rollups[0]
+	first, last := from, to
+	if !from.IsZero() {
+		first = from.Truncate(r.period)
+		if first.Before(from) {
+			first = first.Add(r.period)
+		}
+	}
+	if !to.IsZero() {
+		last = to.Truncate(r.period)
+	}
+	if !first.IsZero() && !last.IsZero() && !first.Before(last) {
+		return rollupSpans(from, to, rollups[1:])
+	}
+
+	spans := []span{{&r, first, last}}
+	if from.Before(first) {
+		spans = append(spans, rollupSpans(from, first, rollups[1:])...)
+	}
+	if !to.IsZero() && last.Before(to) {
+		spans = append(spans, rollupSpans(last, to, rollups[1:])...)
+	}
+	return spans
+}
+
+// countsSQL returns a query of the key and sums c0, c1... per value of a dimension over
+// the query's events that match cond. Without filters whole days and hours are read
+// from the rollups (sums of rollupSums) and only the partial hours at the edges of the
+// range from events (sums of eventSums), which must count the same thing.
+func countsSQL(q Query, dimension, cond string, rollupSums, eventSums []string) (string, []any, error) {
+	expr := Dimensions[dimension]
+	aliased := func(sums []string) string {
+		columns := make([]string, len(sums))
+		for i, sum := range sums {
+			columns[i] = fmt.Sprintf("%s AS c%d", sum, i)
+		}
+		return strings.Join(columns, ", ")
+	}
+	fromEvents := func(q Query, table string) (string, []any, error) {
+		where, args, err := q.where()
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf(`SELECT %s AS key, %s FROM %s WHERE %s AND %s AND %s != '' GROUP BY key`,
+			expr, aliased(eventSums), table, where, cond, expr), args, nil
+	}
+
+	spans := rollupSpans(q.From, q.To, rollups)
+	if len(q.Filters) > 0 || spans[0].rollup == nil {
+		return fromEvents(q, q.eventsTable())
+	}
+
+	var parts []string
+	var args []any
+	for _, s := range spans {
+		if s.rollup == nil {
+			// Partial hours at the edges
+			part, spanArgs, err := fromEvents(Query{WebsiteID: q.WebsiteID, From: s.from, To: s.to}, "events")
+			if err != nil {
+				return "", nil, err
+			}
+			parts = append(parts, part)
+			args = append(args, spanArgs...)
+			continue
+		}
+
+		conditions := []string{"dimension = ?"}
+		args = append(args, dimension)
+		if q.WebsiteID != "" {
+			conditions = append(conditions, "website_id = ?")
+			args = append(args, q.WebsiteID)
+		}
+		if !s.from.IsZero() {
+			conditions = append(conditions, "start >= ?")
+			args = append(args, storedTime(s.from))
+		}
+		if !s.to.IsZero() {
+			conditions = append(conditions, "start < ?")
+			args = append(args, storedTime(s.to))
+		}
+		parts = append(parts, fmt.Sprintf(`SELECT key, %s FROM %s WHERE %s GROUP BY key`,
+			aliased(rollupSums), s.rollup.table, strings.Join(conditions, " AND ")))
+	}
+
+	sums := make([]string, len(rollupSums))
+	for i := range sums {
+		sums[i] = fmt.Sprintf("SUM(c%d) AS c%d", i, i)
+	}
+	return fmt.Sprintf(`SELECT key, %s FROM (%s) GROUP BY key`,
+		strings.Join(sums, ", "), strings.Join(parts, " UNION ALL ")), args, nil
+}
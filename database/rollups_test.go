@@ -0,0 +1,126 @@
+package database
+
+import (
+	"fmt"
+	"gogol_analytics/models"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// rollupRows reads every row of a rollup table as text
+func rollupRows(t *testing.T, table string) []string {
+	t.Helper()
+	rows, err := DB.Query("SELECT dimension, start, website_id, key, views, session_views, entries, exits, bounces FROM " + table + " ORDER BY 1, 2, 3, 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var all []string
+	for rows.Next() {
+		var dimension, start, site, key string
+		var views, sessionViews, entries, exits, bounces int
+		if err := rows.Scan(&dimension, &start, &site, &key, &views, &sessionViews, &entries, &exits, &bounces); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, fmt.Sprint(dimension, start, site, key, views, sessionViews, entries, exits, bounces))
+	}
+	return all
+}
+
+func TestRollupsMatchEvents(t *testing.T) {
+	setupTestDB(t)
+
+	// Two websites over three days, inserted in a scattered order over several batches so
+	// that session flags and their hourly counts are updated after the fact
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-72 * time.Hour)
+	pages := []string{"/", "/pricing", "/blog", "/docs"}
+	countries := []string{"France", "Japan", ""}
+	var events []models.Event
+	for n := 0; n < 600; n++ {
+		i := n * 37 % 600
+		events = append(events, models.Event{
+			WebsiteID:      []string{"A", "B"}[i%2],
+			Timestamp:      base.Add(time.Duration(i*6+i/40*45) * time.Minute).Add(time.Duration(i%7) * time.Second),
+			VisitorID:      fmt.Sprintf("v%d", i%4),
+			NormalizedPath: pages[i*7%len(pages)],
+			Country:        countries[i%len(countries)],
+			ReferrerHost:   []string{"", "google.com"}[i%3%2],
+			IsBot:          i%11 == 0,
+		})
+	}
+	for start := 0; start < len(events); start += 50 {
+		if err := InsertEvents(events[start : start+50]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := map[string]Query{
+		"every event":    {},
+		"whole hours":    {From: base.Add(3 * time.Hour), To: base.Add(20 * time.Hour)},
+		"whole days":     {From: base, To: base.Add(48 * time.Hour)},
+		"days and hours": {From: base.Add(3*time.Hour + 17*time.Minute), To: base.Add(50*time.Hour + 37*time.Minute)},
+		"partial hours":  {From: base.Add(3*time.Hour + 17*time.Minute), To: base.Add(20*time.Hour + 37*time.Minute)},
+		"half-hour zone": {From: base.Add(5 * time.Hour).In(kolkata).Add(30 * time.Minute), To: base.Add(55 * time.Hour).In(kolkata)},
+		"open end":       {From: base.Add(10*time.Hour + 5*time.Minute)},
+		"open start":     {To: base.Add(30*time.Hour + 5*time.Minute)},
+		"one website":    {WebsiteID: "A", From: base.Add(90 * time.Minute), To: base.Add(60 * time.Hour)},
+		"within an hour": {From: base.Add(4*time.Hour + 10*time.Minute), To: base.Add(4*time.Hour + 50*time.Minute)},
+	}
+	// A filter matching every event makes the queries read events only
+	everything := []models.Filter{{Dimension: "hostname", Op: models.FilterRegex, Value: ""}}
+
+	for name, q := range queries {
+		fromEvents := q
+		fromEvents.Filters = everything
+		for _, dimension := range []string{"page", "country", "source"} {
+			got, err := GetTopStats(q, dimension, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := GetTopStats(fromEvents, dimension, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Key < got[j].Key })
+			sort.Slice(want, func(i, j int) bool { return want[i].Key < want[j].Key })
+			if len(want) == 0 || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, %s: got %+v, want %+v", name, dimension, got, want)
+			}
+		}
+		for _, flow := range []func(Query, int) ([]models.PageFlowRow, error){GetEntryPages, GetExitPages} {
+			got, err := flow(q, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := flow(fromEvents, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Key < got[j].Key })
+			sort.Slice(want, func(i, j int) bool { return want[i].Key < want[j].Key })
+			if len(want) == 0 || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, page flow: got %+v, want %+v", name, got, want)
+			}
+		}
+	}
+
+	// The migration's backfill builds the same rows as ingestion
+	for _, r := range rollups {
+		ingested := rollupRows(t, r.table)
+		if _, err := DB.Exec("DELETE FROM " + r.table); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.backfill(); err != nil {
+			t.Fatal(err)
+		}
+		if backfilled := rollupRows(t, r.table); len(ingested) == 0 || !reflect.DeepEqual(backfilled, ingested) {
+			t.Errorf("backfilled %s differ from the ingested rows:\n%v\n%v", r.table, backfilled, ingested)
+		}
+	}
+}
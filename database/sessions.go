@@ -0,0 +1,201 @@
+package database
+
+import (
+	"database/sql"
+	"gogol_analytics/models"
+	"time"
+)
+
+// SessionTimeout is the inactivity gap after which a visitor's next page view starts a new session
+const SessionTimeout = 30 * time.Minute
+
+// sessionView reports whether an event takes part in sessions: human page views
+func sessionView(e models.Event) bool {
+	return !e.IsBot && e.VisitorID != "" && e.NormalizedPath != ""
+}
+
+// sessionWriter keeps the session flags of events up to date as page views are inserted:
+// session_entry marks the first view of a session (the visitor's first view on the website,
+// or the first after more than SessionTimeout) and session_exit its last one. A view that
+// is both is a bounce. Views may arrive out of order, so inserting one also updates the
+// flags of the visitor's views just before and after it, and their hourly counts.
+type sessionWriter struct {
+	prev, next, setEntry, setExit *sql.Stmt
+	rollups                       *rollupWriter
+}
+
+// sessionViews is the condition of the partial index over the views sessions are made of
+const sessionViews = "is_bot = 0 AND normalized_path != '' AND visitor_id != ''"
+
+func prepareSessionWriter(tx *sql.Tx, rollups *rollupWriter) (*sessionWriter, error) {
+	s := sessionWriter{rollups: rollups}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.prev, `SELECT id, timestamp, normalized_path, session_entry, session_exit FROM events
+			WHERE website_id IS ? AND visitor_id = ? AND ` + sessionViews + ` AND timestamp <= ?
+			ORDER BY timestamp DESC, id DESC LIMIT 1`},
+		{&s.next, `SELECT id, timestamp, normalized_path, session_entry, session_exit FROM events
+			WHERE website_id IS ? AND visitor_id = ? AND ` + sessionViews + ` AND timestamp > ?
+			ORDER BY timestamp, id LIMIT 1`},
+		{&s.setEntry, "UPDATE events SET session_entry = ? WHERE id = ?"},
+		{&s.setExit, "UPDATE events SET session_exit = ? WHERE id = ?"},
+	}
+	for _, st := range statements {
+		stmt, err := tx.Prepare(st.query)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		*st.stmt = stmt
+	}
+	return &s, nil
+}
+
+func (s *sessionWriter) Close() {
+	for _, stmt := range []*sql.Stmt{s.prev, s.next, s.setEntry, s.setExit} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// flags returns the session flags of a view about to be inserted and updates those of
+// its neighbours
+func (s *sessionWriter) flags(e models.Event) (entry, exit bool, err error) {
+	at := e.Timestamp.UTC()
+	var (
+		id                int64
+		t                 time.Time
+		path              string
+		wasEntry, wasExit bool
+	)
+
+	err = s.prev.QueryRow(e.WebsiteID, e.VisitorID, at).Scan(&id, &t, &path, &wasEntry, &wasExit)
+	switch {
+	case err == sql.ErrNoRows:
+		entry = true
+	case err != nil:
+		return false, false, err
+	default:
+		// The previous view ended its session only if this one comes after the timeout
+		entry = at.Sub(t) > SessionTimeout
+		if wasExit != entry {
+			if _, err := s.setExit.Exec(entry, id); err != nil {
+				return false, false, err
+			}
+			d := count(entry) - count(wasExit)
+			if err := s.rollups.addSession(e.WebsiteID, path, t, 0, 0, d, d*count(wasEntry)); err != nil {
+				return false, false, err
+			}
+		}
+	}
+
+	err = s.next.QueryRow(e.WebsiteID, e.VisitorID, at).Scan(&id, &t, &path, &wasEntry, &wasExit)
+	switch {
+	case err == sql.ErrNoRows:
+		exit = true
+	case err != nil:
+		return false, false, err
+	default:
+		exit = t.Sub(at) > SessionTimeout
+		if wasEntry != exit {
+			if _, err := s.setEntry.Exec(exit, id); err != nil {
+				return false, false, err
+			}
+			d := count(exit) - count(wasEntry)
+			if err := s.rollups.addSession(e.WebsiteID, path, t, 0, d, 0, d*count(wasExit)); err != nil {
+				return false, false, err
+			}
+		}
+	}
+	return entry, exit, nil
+}
+
+// backfillSessions sets the session flags of events stored before the columns existed
+func backfillSessions() error {
+	_, err := DB.Exec(`
+		WITH views AS (
+			SELECT id, timestamp,
+				LAG(timestamp) OVER visitor AS previous,
+				LEAD(timestamp) OVER visitor AS next
+			FROM events
+			WHERE `+sessionViews+`
+			WINDOW visitor AS (PARTITION BY website_id, visitor_id ORDER BY timestamp, id)
+		)
+		UPDATE events SET
+			session_entry = previous IS NULL OR julianday(views.timestamp) - julianday(previous) > ?,
+			session_exit = next IS NULL OR julianday(next) - julianday(views.timestamp) > ?
+		FROM views
+		WHERE events.id = views.id`, SessionTimeout.Hours()/24, SessionTimeout.Hours()/24)
+	return err
+}
+
+// GetEntryPages returns the pages the query's sessions most often start on, with the share of
+// those sessions that viewed no other page (bounce rate). Filters select the entry views.
+func GetEntryPages(q Query, limit int) ([]models.PageFlowRow, error) {
+	counts, args, err := countsSQL(q, "page", sessionViews,
+		[]string{"SUM(entries)", "SUM(bounces)"},
+		[]string{"SUM(session_entry)", "SUM(session_entry AND session_exit)"})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query(`
+		WITH counts AS (`+counts+`)
+		SELECT key, `+pageTitleSQL("key")+`, c0 as entries, c1
+		FROM counts
+		WHERE entries > 0
+		ORDER BY entries DESC
+		LIMIT ?
+	`, append(args, q.WebsiteID, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.PageFlowRow
+	for rows.Next() {
+		var row models.PageFlowRow
+		if err := rows.Scan(&row.Key, &row.Title, &row.Sessions, &row.Bounces); err != nil {
+			continue
+		}
+		row.BounceRate = float64(row.Bounces) / float64(row.Sessions) * 100
+		stats = append(stats, row)
+	}
+	return stats, rows.Err()
+}
+
+// GetExitPages returns the pages the query's sessions most often end on, with the share of the
+// page's views that were the last of their session (exit rate)
+func GetExitPages(q Query, limit int) ([]models.PageFlowRow, error) {
+	counts, args, err := countsSQL(q, "page", sessionViews,
+		[]string{"SUM(exits)", "SUM(session_views)"},
+		[]string{"SUM(session_exit)", "COUNT(*)"})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query(`
+		WITH counts AS (`+counts+`)
+		SELECT key, `+pageTitleSQL("key")+`, c0 as exits, c1
+		FROM counts
+		WHERE exits > 0
+		ORDER BY exits DESC
+		LIMIT ?
+	`, append(args, q.WebsiteID, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.PageFlowRow
+	for rows.Next() {
+		var row models.PageFlowRow
+		if err := rows.Scan(&row.Key, &row.Title, &row.Sessions, &row.Views); err != nil {
+			continue
+		}
+		row.ExitRate = float64(row.Sessions) / float64(row.Views) * 100
+		stats = append(stats, row)
+	}
+	return stats, rows.Err()
+}
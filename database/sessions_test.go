@@ -0,0 +1,227 @@
+package database
+
+import (
+	"gogol_analytics/models"
+	"testing"
+	"time"
+)
+
+func TestEntryAndExitPages(t *testing.T) {
+	setupTestDB(t)
+
+	start := time.Now().Add(-3 * time.Hour)
+	view := func(visitor, path string, offset time.Duration) models.Event {
+		return models.Event{Timestamp: start.Add(offset), VisitorID: visitor, NormalizedPath: path}
+	}
+	err := InsertEvents([]models.Event{
+		// a: two sessions, "/" → "/pricing" then, after an hour, a bounce on "/blog"
+		view("a", "/", 0),
+		view("a", "/pricing", 5*time.Minute),
+		view("a", "/blog", 65*time.Minute),
+		// b: bounce on "/"
+		view("b", "/", 10*time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := GetEntryPages(Query{From: time.Now().Add(-24 * time.Hour)}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != "/" || entries[0].Sessions != 2 || entries[0].BounceRate != 50 {
+		t.Errorf("unexpected entry pages: %+v", entries)
+	}
+
+	exits, err := GetExitPages(Query{From: time.Now().Add(-24 * time.Hour)}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]models.PageFlowRow{}
+	for _, row := range exits {
+		got[row.Key] = row
+	}
+	if len(got) != 3 || got["/"].Sessions != 1 || got["/"].ExitRate != 50 || got["/pricing"].ExitRate != 100 {
+		t.Errorf("unexpected exit pages: %+v", exits)
+	}
+}
+
+func TestPageTitlesPerWebsite(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	err := InsertEvents([]models.Event{
+		{WebsiteID: "a", Timestamp: now.Add(-2 * time.Minute), VisitorID: "x", NormalizedPath: "/about", PageTitle: "About A"},
+		{WebsiteID: "b", Timestamp: now.Add(-time.Minute), VisitorID: "y", NormalizedPath: "/about", PageTitle: "About B"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := Query{WebsiteID: "a", From: now.Add(-time.Hour)}
+	pages, err := GetTopStats(q, "page", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Title != "About A" {
+		t.Errorf("expected the title of website a, got %+v", pages)
+	}
+	entries, err := GetEntryPages(q, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Title != "About A" {
+		t.Errorf("expected the title of website a, got %+v", entries)
+	}
+
+	// Every website: the latest title wins
+	if pages, err = GetTopStats(Query{From: now.Add(-time.Hour)}, "page", 10); err != nil || len(pages) != 1 || pages[0].Title != "About B" {
+		t.Errorf("expected the latest title, got %+v (%v)", pages, err)
+	}
+}
+
+// sessionFlags reads the session flags of every event by visitor and path
+func sessionFlags(t *testing.T) map[string][2]bool {
+	t.Helper()
+	rows, err := DB.Query("SELECT visitor_id, normalized_path, session_entry, session_exit FROM events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	flags := map[string][2]bool{}
+	for rows.Next() {
+		var visitor, path string
+		var entry, exit bool
+		if err := rows.Scan(&visitor, &path, &entry, &exit); err != nil {
+			t.Fatal(err)
+		}
+		flags[visitor+" "+path] = [2]bool{entry, exit}
+	}
+	return flags
+}
+
+func TestSessionFlagsOutOfOrder(t *testing.T) {
+	setupTestDB(t)
+
+	start := time.Now().Add(-3 * time.Hour)
+	view := func(visitor, path string, offset time.Duration) models.Event {
+		return models.Event{WebsiteID: "site", Timestamp: start.Add(offset), VisitorID: visitor, NormalizedPath: path}
+	}
+	// a: "/1" → "/2" → "/3", then a bounce on "/4" after an hour; inserted in a mixed
+	// order across batches, as imported logs may be
+	batches := [][]models.Event{
+		{view("a", "/3", 20*time.Minute), view("a", "/4", 90*time.Minute)},
+		{view("a", "/1", 0)},
+		{view("a", "/2", 10*time.Minute), {WebsiteID: "site", Timestamp: start.Add(15 * time.Minute), VisitorID: "a", NormalizedPath: "/bot", IsBot: true}},
+	}
+	for _, batch := range batches {
+		if err := InsertEvents(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string][2]bool{
+		"a /1":   {true, false},
+		"a /2":   {false, false},
+		"a /3":   {false, true},
+		"a /4":   {true, true},
+		"a /bot": {false, false},
+	}
+	got := sessionFlags(t)
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("%s: entry, exit = %v, want %v", key, got[key], w)
+		}
+	}
+}
+
+func TestSessionsPerWebsite(t *testing.T) {
+	setupTestDB(t)
+
+	// One hashed visitor goes from site A to site B within the timeout
+	start := time.Now().Add(-3 * time.Hour)
+	err := InsertEvents([]models.Event{
+		{WebsiteID: "A", Timestamp: start, VisitorID: "v", NormalizedPath: "/a"},
+		{WebsiteID: "A", Timestamp: start.Add(5 * time.Minute), VisitorID: "v", NormalizedPath: "/a/checkout"},
+		{WebsiteID: "B", Timestamp: start.Add(10 * time.Minute), VisitorID: "v", NormalizedPath: "/b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := Query{From: time.Now().Add(-24 * time.Hour)}
+
+	entries, err := GetEntryPages(q, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]models.PageFlowRow{}
+	for _, row := range entries {
+		got[row.Key] = row
+	}
+	if len(got) != 2 || got["/a"].Sessions != 1 || got["/a"].BounceRate != 0 || got["/b"].Sessions != 1 || got["/b"].BounceRate != 100 {
+		t.Errorf("expected one session per website, got entry pages %+v", entries)
+	}
+
+	exits, err := GetExitPages(q, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = map[string]models.PageFlowRow{}
+	for _, row := range exits {
+		got[row.Key] = row
+	}
+	if len(got) != 2 || got["/a/checkout"].Sessions != 1 || got["/b"].Sessions != 1 {
+		t.Errorf("expected one session per website, got exit pages %+v", exits)
+	}
+}
+
+func TestEntryAndExitPagesWithFilters(t *testing.T) {
+	setupTestDB(t)
+
+	start := time.Now().Add(-3 * time.Hour)
+	view := func(visitor, path, country string, offset time.Duration) models.Event {
+		return models.Event{Timestamp: start.Add(offset), VisitorID: visitor, NormalizedPath: path, Country: country}
+	}
+	err := InsertEvents([]models.Event{
+		// a: "/" → "/pricing", b: bounce on "/", c: "/pricing" → "/"
+		view("a", "/", "France", 0),
+		view("a", "/pricing", "France", 5*time.Minute),
+		view("b", "/", "Japan", 10*time.Minute),
+		view("c", "/pricing", "France", 15*time.Minute),
+		view("c", "/", "France", 20*time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Now().Add(-24 * time.Hour)
+
+	// Sessions keep their other pages: only b bounced among the sessions entering on "/"
+	page := []models.Filter{{Dimension: "page", Op: models.FilterEquals, Value: "/"}}
+	entries, err := GetEntryPages(Query{From: from, Filters: page}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "/" || entries[0].Sessions != 2 || entries[0].BounceRate != 50 {
+		t.Errorf("unexpected entry pages: %+v", entries)
+	}
+	exits, err := GetExitPages(Query{From: from, Filters: page}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "/" was viewed 3 times, the last view of b's and c's sessions
+	if len(exits) != 1 || exits[0].Key != "/" || exits[0].Sessions != 2 || exits[0].Views != 3 {
+		t.Errorf("unexpected exit pages: %+v", exits)
+	}
+
+	country := []models.Filter{{Dimension: "country", Op: models.FilterEquals, Value: "France"}}
+	if entries, err = GetEntryPages(Query{From: from, Filters: country}, 10); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]models.PageFlowRow{}
+	for _, row := range entries {
+		got[row.Key] = row
+	}
+	if len(got) != 2 || got["/"].Sessions != 1 || got["/"].BounceRate != 0 || got["/pricing"].Sessions != 1 {
+		t.Errorf("unexpected entry pages from France: %+v", entries)
+	}
+}
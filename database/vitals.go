@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"gogol_analytics/models"
+	"strings"
+)
+
+func insertWebVitals(tx *sql.Tx, vitals []models.WebVitals) error {
+	if len(vitals) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO web_vitals (
+		website_id, timestamp, path, device, browser, lcp, cls, inp, fcp, ttfb
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, v := range vitals {
+		if _, err := stmt.Exec(v.WebsiteID, v.Timestamp.UTC(), v.Path, v.Device, v.Browser,
+			v.LCP, v.CLS, v.INP, v.FCP, v.TTFB); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findVitalMetric looks up a metric by name (case-insensitive)
+func findVitalMetric(name string) (models.VitalMetric, bool) {
+	for _, m := range models.VitalMetrics {
+		if strings.EqualFold(m.Name, name) {
+			return m, true
+		}
+	}
+	return models.VitalMetric{}, false
+}
+
+// GetVitalsOverview summarizes every metric over the time range
+func GetVitalsOverview(timeRange string) ([]models.MetricOverview, error) {
+	var overview []models.MetricOverview
+	for _, m := range models.VitalMetrics {
+		rows, err := GetVitalsBreakdown(timeRange, m.Name, "", 1)
+		if err != nil {
+			return nil, err
+		}
+		item := models.MetricOverview{Metric: m}
+		if len(rows) > 0 {
+			item.Summary = rows[0].Summary
+		}
+		overview = append(overview, item)
+	}
+	return overview, nil
+}
+
+// GetVitalsBreakdown summarizes one metric per page ("path"), "device" or "browser",
+// ordered by sample count. An empty dimension gives a single site-wide row.
+// Percentiles are ranked in SQL so that only the summary rows are read.
+func GetVitalsBreakdown(timeRange, metricName, dimension string, limit int) ([]models.VitalsRow, error) {
+	metric, ok := findVitalMetric(metricName)
+	if !ok {
+		return nil, fmt.Errorf("invalid metric")
+	}
+	groupExpr := "''"
+	if dimension != "" {
+		allowed := map[string]bool{"path": true, "device": true, "browser": true}
+		if !allowed[dimension] {
+			return nil, fmt.Errorf("invalid dimension")
+		}
+		groupExpr = dimension
+	}
+	column := strings.ToLower(metric.Name)
+
+	rows, err := DB.Query(fmt.Sprintf(`
+		SELECT key, COUNT(*) AS samples, %s, %s, %s,
+			SUM(CASE WHEN value <= ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN value > ? THEN 1 ELSE 0 END)
+		FROM (
+			SELECT %s AS key, %s AS value,
+				ROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s) AS rank,
+				COUNT(*) OVER (PARTITION BY %s) AS n
+			FROM web_vitals
+			WHERE timestamp >= ? AND %s IS NOT NULL
+		)
+		GROUP BY key
+		ORDER BY samples DESC, key
+		LIMIT ?
+	`, percentileSQL(50), percentileSQL(75), percentileSQL(95),
+		groupExpr, column, groupExpr, column, groupExpr, column),
+		metric.Good, metric.Poor, rangeStart(timeRange), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.VitalsRow
+	for rows.Next() {
+		var row models.VitalsRow
+		s := &row.Summary
+		if err := rows.Scan(&row.Key, &s.Samples, &s.P50, &s.P75, &s.P95, &s.Good, &s.Poor); err != nil {
+			return nil, err
+		}
+		s.NeedsImprovement = s.Samples - s.Good - s.Poor
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// percentileSQL picks the nearest-rank percentile p of the ranked values of a group:
+// the value ranked ceil(p/100 * n), never below the first.
+// Example: LCP samples [1200, 2600, 4100] -> P50 2600, P75 4100, P95 4100.
+func percentileSQL(p int) string {
+	return fmt.Sprintf("MAX(CASE WHEN rank = MAX((%d * n + 99) / 100, 1) THEN value END)", p)
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"gogol_analytics/models"
+	"testing"
+	"time"
+)
+
+func TestVitalsPercentiles(t *testing.T) {
+	tests := []struct {
+		name           string
+		lcp            []float64
+		p50, p75, p95  float64
+		good, ni, poor int
+	}{
+		{name: "no samples"},
+		{name: "one sample", lcp: []float64{3000}, p50: 3000, p75: 3000, p95: 3000, ni: 1},
+		{name: "two samples", lcp: []float64{4100, 1200}, p50: 1200, p75: 4100, p95: 4100, good: 1, poor: 1},
+		{name: "three samples", lcp: []float64{2600, 4100, 1200}, p50: 2600, p75: 4100, p95: 4100, good: 1, ni: 1, poor: 1},
+		{name: "thresholds are inclusive of good", lcp: []float64{2500, 4000, 4000.5}, p50: 4000, p75: 4000.5, p95: 4000.5, good: 1, ni: 1, poor: 1},
+		{name: "twenty samples",
+			lcp: []float64{20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1},
+			p50: 10, p75: 15, p95: 19, good: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			for i, v := range tt.lcp {
+				lcp := v
+				err := EnqueueWebVitals(models.WebVitals{WebsiteID: "site", Timestamp: time.Now().Add(-time.Duration(i) * time.Minute), Path: "/", LCP: &lcp})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A sample without LCP is not counted
+			cls := 0.05
+			if err := EnqueueWebVitals(models.WebVitals{WebsiteID: "site", Timestamp: time.Now(), Path: "/", CLS: &cls}); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := GetVitalsBreakdown("24h", "lcp", "", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.lcp) == 0 {
+				if len(rows) != 0 {
+					t.Errorf("expected no rows, got %+v", rows)
+				}
+				return
+			}
+			if len(rows) != 1 {
+				t.Fatalf("expected one row, got %+v", rows)
+			}
+			s := rows[0].Summary
+			if s.Samples != len(tt.lcp) || s.P50 != tt.p50 || s.P75 != tt.p75 || s.P95 != tt.p95 ||
+				s.Good != tt.good || s.NeedsImprovement != tt.ni || s.Poor != tt.poor {
+				t.Errorf("got %+v", s)
+			}
+		})
+	}
+}
+
+func TestVitalsBreakdownOrder(t *testing.T) {
+	setupTestDB(t)
+	for i, path := range []string{"/a", "/b", "/b", "/c", "/c", "/c"} {
+		inp := float64(100 * (i + 1))
+		if err := EnqueueWebVitals(models.WebVitals{WebsiteID: "site", Timestamp: time.Now(), Path: path, INP: &inp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := GetVitalsBreakdown("24h", "INP", "path", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Key != "/c" || rows[0].Summary.Samples != 3 || rows[0].Summary.P50 != 500 ||
+		rows[1].Key != "/b" || rows[1].Summary.Poor != 0 || rows[1].Summary.NeedsImprovement != 1 {
+		t.Errorf("unexpected breakdown %+v", rows)
+	}
+	if _, err := GetVitalsBreakdown("24h", "INP", "country", 2); err == nil {
+		t.Error("expected an error for an unknown dimension")
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gogol_analytics/models"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by EnqueueEvent when the ingestion queue has no room left.
+// Callers should ask the client to retry later instead of blocking the request.
+var ErrQueueFull = errors.New("event queue is full")
+
+// WriterConfig controls the background event writer
+type WriterConfig struct {
+	QueueSize     int           // Maximum number of events waiting to be written
+	BatchSize     int           // Maximum number of events per transaction
+	FlushInterval time.Duration // Maximum time an event waits before its batch is committed
+}
+
+// DefaultWriterConfig is used by the server unless overridden
+var DefaultWriterConfig = WriterConfig{
+	QueueSize:     10000,
+	BatchSize:     500,
+	FlushInterval: time.Second,
+}
+
+// QueueStats describes the current state of the ingestion queue
+type QueueStats struct {
+	Depth    int  `json:"queue_depth"`
+	Capacity int  `json:"queue_capacity"`
+	Running  bool `json:"running"`
+}
+
+type eventWriter struct {
+	queue chan any // models.Event, models.WebVitals, models.JSError or models.CustomEvent
+	cfg   WriterConfig
+	done  chan struct{}
+}
+
+var (
+	writer      *eventWriter
+	writerMutex sync.RWMutex
+)
+
+// StartWriter launches the background goroutine that drains the ingestion queue
+// into the database, grouping events into one transaction per batch.
+func StartWriter(cfg WriterConfig) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultWriterConfig.QueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultWriterConfig.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultWriterConfig.FlushInterval
+	}
+
+	writerMutex.Lock()
+	defer writerMutex.Unlock()
+	if writer != nil {
+		return
+	}
+
+	writer = &eventWriter{
+		queue: make(chan any, cfg.QueueSize),
+		cfg:   cfg,
+		done:  make(chan struct{}),
+	}
+	go writer.run()
+}
+
+// StopWriter closes the queue and waits until every pending event has been written
+func StopWriter() {
+	writerMutex.Lock()
+	w := writer
+	writer = nil
+	writerMutex.Unlock()
+
+	if w == nil {
+		return
+	}
+	close(w.queue)
+	<-w.done
+}
+
+// EnqueueEvent hands an event to the background writer without waiting for the database.
+// If the writer is not running the event is written synchronously instead.
+func EnqueueEvent(e models.Event) error {
+	return enqueue(e)
+}
+
+// EnqueueWebVitals queues a Core Web Vitals sample like EnqueueEvent
+func EnqueueWebVitals(v models.WebVitals) error {
+	return enqueue(v)
+}
+
+// EnqueueJSError queues a client-side JavaScript error like EnqueueEvent
+func EnqueueJSError(e models.JSError) error {
+	return enqueue(e)
+}
+
+// EnqueueCustomEvent queues a custom event like EnqueueEvent
+func EnqueueCustomEvent(e models.CustomEvent) error {
+	return enqueue(e)
+}
+
+// EnqueueBatch queues the events and custom events of one request together: if the
+// queue has no room for all of them, none is queued and ErrQueueFull is returned,
+// so that the client can retry the whole batch without duplicates.
+// If the writer is not running they are written synchronously in one transaction.
+func EnqueueBatch(events []models.Event, custom []models.CustomEvent) error {
+	records := make([]any, 0, len(events)+len(custom))
+	for _, e := range events {
+		records = append(records, e)
+	}
+	for _, e := range custom {
+		records = append(records, e)
+	}
+
+	// The write lock keeps other producers out, so the room checked stays free
+	writerMutex.Lock()
+	defer writerMutex.Unlock()
+
+	if writer == nil {
+		return writeBatch(records)
+	}
+	if cap(writer.queue)-len(writer.queue) < len(records) {
+		return ErrQueueFull
+	}
+	for _, record := range records {
+		writer.queue <- record
+	}
+	return nil
+}
+
+func enqueue(record any) error {
+	writerMutex.RLock()
+	defer writerMutex.RUnlock()
+
+	if writer == nil {
+		return writeBatch([]any{record})
+	}
+
+	select {
+	case writer.queue <- record:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// writeBatch stores a batch of queued records in one transaction
+func writeBatch(records []any) error {
+	var (
+		events []models.Event
+		vitals []models.WebVitals
+		errs   []models.JSError
+		custom []models.CustomEvent
+	)
+	for _, record := range records {
+		switch r := record.(type) {
+		case models.Event:
+			events = append(events, r)
+		case models.WebVitals:
+			vitals = append(vitals, r)
+		case models.JSError:
+			errs = append(errs, r)
+		case models.CustomEvent:
+			custom = append(custom, r)
+		default:
+			return fmt.Errorf("writer: unsupported record %T", record)
+		}
+	}
+
+	return inTx(func(tx *sql.Tx) error {
+		if err := insertEvents(tx, events); err != nil {
+			return err
+		}
+		if err := insertWebVitals(tx, vitals); err != nil {
+			return err
+		}
+		if err := insertJSErrors(tx, errs); err != nil {
+			return err
+		}
+		return insertCustomEvents(tx, custom)
+	})
+}
+
+// GetQueueStats reports how many events are waiting to be written
+func GetQueueStats() QueueStats {
+	writerMutex.RLock()
+	defer writerMutex.RUnlock()
+
+	if writer == nil {
+		return QueueStats{}
+	}
+	return QueueStats{
+		Depth:    len(writer.queue),
+		Capacity: cap(writer.queue),
+		Running:  true,
+	}
+}
+
+func (w *eventWriter) run() {
+	defer close(w.done)
+
+	batch := make([]any, 0, w.cfg.BatchSize)
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := writeBatch(batch); err != nil {
+			fmt.Printf("DB Error (writer, %d records): %v\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case record, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= w.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
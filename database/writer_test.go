@@ -0,0 +1,74 @@
+package database
+
+import (
+	"errors"
+	"gogol_analytics/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	os.Setenv("DB_PATH", filepath.Join(t.TempDir(), "gogol_test.db"))
+	InitDB()
+	t.Cleanup(func() { DB.Close() })
+}
+
+func countEvents(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM events").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWriterFlushesOnStop(t *testing.T) {
+	setupTestDB(t)
+	StartWriter(WriterConfig{QueueSize: 100, BatchSize: 10, FlushInterval: time.Hour})
+
+	for i := 0; i < 25; i++ {
+		if err := EnqueueEvent(models.Event{Timestamp: time.Now(), VisitorID: "v"}); err != nil {
+			t.Fatalf("enqueue %d: %v", i, err)
+		}
+	}
+	StopWriter()
+
+	if n := countEvents(t); n != 25 {
+		t.Errorf("expected 25 events after stop, got %d", n)
+	}
+}
+
+func TestWriterRejectsWhenFull(t *testing.T) {
+	setupTestDB(t)
+
+	// Hold the database so the writer cannot drain the queue
+	tx, err := DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("DELETE FROM events"); err != nil {
+		t.Fatal(err)
+	}
+
+	StartWriter(WriterConfig{QueueSize: 2, BatchSize: 1, FlushInterval: time.Hour})
+	defer StopWriter()
+
+	var full bool
+	for i := 0; i < 10; i++ {
+		if err := EnqueueEvent(models.Event{Timestamp: time.Now()}); errors.Is(err, ErrQueueFull) {
+			full = true
+			break
+		}
+	}
+	if !full {
+		t.Error("expected ErrQueueFull once the queue is saturated")
+	}
+	if stats := GetQueueStats(); !stats.Running || stats.Capacity != 2 {
+		t.Errorf("unexpected queue stats: %+v", stats)
+	}
+
+	tx.Rollback()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gogol_analytics/database"
+	"gogol_analytics/export"
+	"os"
+	"slices"
+	"strings"
+)
+
+// exportEvents implements "gogol export": it writes a website's raw events as CSV,
+// NDJSON or Parquet to a file or standard output
+func exportEvents(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	site := fs.String("site", "", "website ID (required)")
+	format := fs.String("format", "csv", `"csv", "ndjson" or "parquet"`)
+	from := fs.String("from", "", "first day to export (YYYY-MM-DD); default: first event")
+	to := fs.String("to", "", "last day to export (YYYY-MM-DD); default: today")
+	columns := fs.String("columns", "", "comma-separated columns; default: all")
+	output := fs.String("o", "-", `output file, "-" for standard output`)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gogol export -site SITE_ID [flags]")
+		fs.PrintDefaults()
+		names := make([]string, len(database.ExportColumns))
+		for i, c := range database.ExportColumns {
+			names[i] = c.Name
+		}
+		fmt.Fprintf(fs.Output(), "Columns: %s\n", strings.Join(names, ", "))
+	}
+	fs.Parse(args)
+	if *site == "" || fs.NArg() > 0 || !slices.Contains(export.Formats, *format) {
+		fs.Usage()
+		return 2
+	}
+	selected, err := export.Columns(*columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	database.InitDB()
+	website, ok, err := database.GetWebsite(*site)
+	if err != nil || !ok {
+		fmt.Fprintf(os.Stderr, "Unknown website %q %v\n", *site, err)
+		return 1
+	}
+	// Days are read in the website's time zone
+	start, end, err := export.ParseRange(*from, *to, website.Location())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	err = export.Events(out, *format, *site, start, end, selected)
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
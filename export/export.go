@@ -0,0 +1,171 @@
+// Package export encodes raw events as CSV, NDJSON or Parquet. Writers receive
+// rows one at a time and only buffer what the format needs (a Parquet row group),
+// so exports can be streamed straight to an HTTP response or a file.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gogol_analytics/database"
+	"gogol_analytics/models"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats supported by NewWriter
+var Formats = []string{"csv", "ndjson", "parquet"}
+
+// TimeLayout is used for timestamps in CSV and NDJSON (RFC 3339 with milliseconds)
+const TimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Writer encodes exported rows. Values are passed in column order as string,
+// int64, bool or time.Time.
+type Writer interface {
+	Write(values []any) error
+	// Close flushes buffered rows and writes the format's trailer; it does not
+	// close the underlying io.Writer
+	Close() error
+}
+
+// NewWriter returns a Writer for format ("csv", "ndjson" or "parquet")
+func NewWriter(format string, w io.Writer, columns []models.ExportColumn) (Writer, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.Name
+		}
+		if err := cw.Write(header); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw, record: make([]string, len(columns))}, nil
+	case "ndjson":
+		return &ndjsonWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case "parquet":
+		return newParquetWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8"
+	case "ndjson":
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// Columns resolves a comma-separated column selection; empty selects every column
+func Columns(selection string) ([]models.ExportColumn, error) {
+	var names []string
+	if selection != "" {
+		names = strings.Split(selection, ",")
+	}
+	return database.ExportColumnsByName(names)
+}
+
+// Events streams a website's events logged in [from, to) to w
+func Events(w io.Writer, format, websiteID string, from, to time.Time, columns []models.ExportColumn) error {
+	writer, err := NewWriter(format, w, columns)
+	if err != nil {
+		return err
+	}
+	if err := database.ExportEvents(websiteID, from, to, columns, writer.Write); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// ParseRange reads the inclusive YYYY-MM-DD bounds of an export in a time zone
+// (the website's). An empty from starts at the first event, an empty to ends now.
+func ParseRange(from, to string, loc *time.Location) (start, end time.Time, err error) {
+	end = time.Now().Add(time.Minute)
+	if from != "" {
+		if start, err = time.ParseInLocation("2006-01-02", from, loc); err != nil {
+			return start, end, fmt.Errorf("invalid from date %q", from)
+		}
+	}
+	if to != "" {
+		day, err := time.ParseInLocation("2006-01-02", to, loc)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid to date %q", to)
+		}
+		end = day.AddDate(0, 0, 1)
+	}
+	if !start.IsZero() && !end.After(start) {
+		return start, end, fmt.Errorf("to is before from")
+	}
+	return start, end, nil
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(TimeLayout)
+	}
+	return fmt.Sprint(v)
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (c *csvWriter) Write(values []any) error {
+	for i, v := range values {
+		c.record[i] = formatValue(v)
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	columns []models.ExportColumn
+}
+
+// Write emits one object per line with keys in column order
+func (n *ndjsonWriter) Write(values []any) error {
+	n.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(n.columns[i].Name)
+		n.w.Write(key)
+		n.w.WriteByte(':')
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(TimeLayout)
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n.w.Write(value)
+	}
+	n.w.WriteByte('}')
+	return n.w.WriteByte('\n')
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
@@ -0,0 +1,216 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gogol_analytics/models"
+	"testing"
+	"time"
+)
+
+var testColumns = []models.ExportColumn{
+	{Name: "id", Type: models.ColumnInt},
+	{Name: "timestamp", Type: models.ColumnTime},
+	{Name: "path", Type: models.ColumnString},
+	{Name: "is_bot", Type: models.ColumnBool},
+}
+
+func writeRows(t *testing.T, format string, rows int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	for i := 0; i < rows; i++ {
+		if err := w.Write([]any{int64(i + 1), ts, `/a,"b"`, i%2 == 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSVAndNDJSON(t *testing.T) {
+	csv := string(writeRows(t, "csv", 1))
+	if want := "id,timestamp,path,is_bot\n1,2024-01-02T03:04:05.006Z,\"/a,\"\"b\"\"\",true\n"; csv != want {
+		t.Errorf("csv = %q, want %q", csv, want)
+	}
+
+	ndjson := string(writeRows(t, "ndjson", 1))
+	if want := `{"id":1,"timestamp":"2024-01-02T03:04:05.006Z","path":"/a,\"b\"","is_bot":true}` + "\n"; ndjson != want {
+		t.Errorf("ndjson = %q, want %q", ndjson, want)
+	}
+}
+
+func TestParquetLayout(t *testing.T) {
+	RowGroupSize = 3
+	defer func() { RowGroupSize = 10000 }()
+
+	file := writeRows(t, "parquet", 7)
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatal("missing Parquet magic bytes")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footerStart := len(file) - 8 - footerLen
+	r := &thriftReader{t: t, b: file[footerStart : len(file)-8]}
+	meta := r.readStruct()
+	if r.pos != footerLen {
+		t.Errorf("footer decoded %d bytes of %d", r.pos, footerLen)
+	}
+
+	// FileMetaData: version, schema, num_rows, row_groups, created_by
+	if meta[1] != int64(1) || meta[3] != int64(7) || meta[6] != "gogol" {
+		t.Errorf("unexpected file metadata %v", meta)
+	}
+	schema := meta[2].([]any)
+	if len(schema) != len(testColumns)+1 {
+		t.Fatalf("expected %d schema elements, got %d", len(testColumns)+1, len(schema))
+	}
+	if root := schema[0].(map[int16]any); root[4] != "schema" || root[5] != int64(len(testColumns)) {
+		t.Errorf("unexpected schema root %v", root)
+	}
+	wantTypes := []int64{parquetInt64, parquetInt64, parquetByteArray, parquetBoolean}
+	for i, col := range testColumns {
+		element := schema[i+1].(map[int16]any)
+		if element[4] != col.Name || element[1] != wantTypes[i] || element[3] != int64(repetitionRequired) {
+			t.Errorf("unexpected schema element %v for %s", element, col.Name)
+		}
+	}
+
+	// Row groups of 3, 3 and 1 rows whose column chunks follow each other from the
+	// first magic bytes to the footer
+	groups := meta[4].([]any)
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 row groups, got %d", len(groups))
+	}
+	offset := int64(4)
+	for g, wantRows := range []int64{3, 3, 1} {
+		group := groups[g].(map[int16]any)
+		if group[3] != wantRows {
+			t.Errorf("row group %d: num_rows %v, want %d", g, group[3], wantRows)
+		}
+		var groupSize int64
+		for i, chunk := range group[1].([]any) {
+			chunk := chunk.(map[int16]any)
+			cm := chunk[3].(map[int16]any)
+			size := cm[6].(int64)
+			if chunk[2] != offset || cm[9] != offset || cm[7] != size || cm[5] != wantRows || cm[1] != wantTypes[i] {
+				t.Errorf("row group %d, column %d: unexpected chunk %v at offset %d", g, i, chunk, offset)
+			}
+
+			// PageHeader: type, uncompressed and compressed sizes, data page header
+			page := &thriftReader{t: t, b: file[offset : offset+size]}
+			header := page.readStruct()
+			data := header[5].(map[int16]any)
+			dataLen := int64(len(page.b) - page.pos)
+			if header[1] != int64(pageTypeData) || header[2] != dataLen || header[3] != dataLen ||
+				data[1] != wantRows || data[2] != int64(encodingPlain) {
+				t.Errorf("row group %d, column %d: unexpected page header %v", g, i, header)
+			}
+			values := page.b[page.pos:]
+			switch testColumns[i].Name {
+			case "id":
+				if first := int64(binary.LittleEndian.Uint64(values)); first != int64(g*3+1) {
+					t.Errorf("row group %d: first id %d", g, first)
+				}
+			case "timestamp":
+				if ms := int64(binary.LittleEndian.Uint64(values)); ms != time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC).UnixMilli() {
+					t.Errorf("row group %d: timestamp %d", g, ms)
+				}
+			case "path":
+				if n := binary.LittleEndian.Uint32(values); string(values[4:4+n]) != `/a,"b"` {
+					t.Errorf("row group %d: path %q", g, values[4:4+n])
+				}
+			case "is_bot":
+				// Rows 1, 3, 5 and 7 (odd ids) are bots, least significant bit first
+				if want := []byte{0b101, 0b010, 0b1}[g]; len(values) != 1 || values[0] != want {
+					t.Errorf("row group %d: is_bot bits %08b, want %08b", g, values, want)
+				}
+			}
+
+			offset += size
+			groupSize += size
+		}
+		if group[2] != groupSize {
+			t.Errorf("row group %d: total_byte_size %v, want %d", g, group[2], groupSize)
+		}
+	}
+	if offset != int64(footerStart) {
+		t.Errorf("column chunks end at %d, footer starts at %d", offset, footerStart)
+	}
+
+	if _, err := NewWriter("xml", &bytes.Buffer{}, testColumns); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// thriftReader decodes the thrift compact protocol into maps of field id to
+// int64, string, []any or nested map values
+type thriftReader struct {
+	t   *testing.T
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("invalid varint at %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	fields := map[int16]any{}
+	var last int16
+	for {
+		b := r.b[r.pos]
+		r.pos++
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		last = id
+		fields[id] = r.value(b & 0x0f)
+	}
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.uvarint())
+		r.pos += n
+		return string(r.b[r.pos-n : r.pos])
+	case thriftList:
+		header := r.b[r.pos]
+		r.pos++
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.t.Fatalf("unsupported thrift type %d at %d", typ, r.pos)
+	return nil
+}
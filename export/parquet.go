@@ -0,0 +1,285 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"gogol_analytics/models"
+	"io"
+	"time"
+)
+
+// The Parquet writer produces uncompressed, PLAIN-encoded files with one data page
+// per column chunk and every column REQUIRED, which any Parquet reader accepts.
+// Only the thrift structures needed for that are encoded below.
+
+// RowGroupSize is the number of rows buffered before a row group is written
+var RowGroupSize = 10000
+
+// Parquet physical types, converted types and enums from parquet.thrift
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionRequired = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+)
+
+type columnChunk struct {
+	offset int64
+	size   int64
+}
+
+type rowGroup struct {
+	rows   int
+	chunks []columnChunk
+}
+
+type parquetWriter struct {
+	w         *bufio.Writer
+	offset    int64
+	columns   []models.ExportColumn
+	values    []bytes.Buffer // PLAIN-encoded values of the current row group, per column
+	rows      int
+	rowGroups []rowGroup
+	total     int64
+}
+
+func newParquetWriter(w io.Writer, columns []models.ExportColumn) (*parquetWriter, error) {
+	p := &parquetWriter{
+		w:       bufio.NewWriter(w),
+		columns: columns,
+		values:  make([]bytes.Buffer, len(columns)),
+	}
+	return p, p.write([]byte("PAR1"))
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+func (p *parquetWriter) Write(values []any) error {
+	for i, v := range values {
+		buf := &p.values[i]
+		switch v := v.(type) {
+		case string:
+			buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(v))))
+			buf.WriteString(v)
+		case int64:
+			buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+		case time.Time:
+			buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(v.UnixMilli())))
+		case bool:
+			// Booleans are bit-packed, least significant bit first
+			if p.rows%8 == 0 {
+				buf.WriteByte(0)
+			}
+			if v {
+				buf.Bytes()[buf.Len()-1] |= 1 << (p.rows % 8)
+			}
+		}
+	}
+	p.rows++
+	if p.rows >= RowGroupSize {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flushRowGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	group := rowGroup{rows: p.rows}
+	for i := range p.columns {
+		data := p.values[i].Bytes()
+
+		var header bytes.Buffer
+		c := compact{b: &header}
+		c.i32(1, pageTypeData)
+		c.i32(2, int32(len(data)))
+		c.i32(3, int32(len(data)))
+		c.structField(5, func(c *compact) {
+			c.i32(1, int32(p.rows))
+			c.i32(2, encodingPlain)
+			c.i32(3, encodingRLE)
+			c.i32(4, encodingRLE)
+		})
+		header.WriteByte(0)
+
+		chunk := columnChunk{offset: p.offset, size: int64(header.Len() + len(data))}
+		if err := p.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(data); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		p.values[i].Reset()
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var footer bytes.Buffer
+	c := compact{b: &footer}
+	c.i32(1, 1) // version
+	c.list(2, thriftStruct, len(p.columns)+1)
+	c.element(func(c *compact) {
+		c.str(4, "schema")
+		c.i32(5, int32(len(p.columns)))
+	})
+	for _, col := range p.columns {
+		c.element(func(c *compact) {
+			c.i32(1, physicalType(col.Type))
+			c.i32(3, repetitionRequired)
+			c.str(4, col.Name)
+			switch col.Type {
+			case models.ColumnString:
+				c.i32(6, convertedUTF8)
+			case models.ColumnTime:
+				c.i32(6, convertedTimestampMillis)
+			}
+		})
+	}
+	c.i64(3, p.total)
+	c.list(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		c.element(func(c *compact) {
+			var size int64
+			c.list(1, thriftStruct, len(group.chunks))
+			for i, chunk := range group.chunks {
+				size += chunk.size
+				c.element(func(c *compact) {
+					c.i64(2, chunk.offset)
+					c.structField(3, func(c *compact) {
+						c.i32(1, physicalType(p.columns[i].Type))
+						c.list(2, thriftI32, 1)
+						c.varint(zigzag(encodingPlain))
+						c.list(3, thriftBinary, 1)
+						c.varint(uint64(len(p.columns[i].Name)))
+						c.b.WriteString(p.columns[i].Name)
+						c.i32(4, codecUncompressed)
+						c.i64(5, int64(group.rows))
+						c.i64(6, chunk.size)
+						c.i64(7, chunk.size)
+						c.i64(9, chunk.offset)
+					})
+				})
+			}
+			c.i64(2, size)
+			c.i64(3, int64(group.rows))
+		})
+	}
+	c.str(6, "gogol")
+	footer.WriteByte(0)
+
+	if err := p.write(footer.Bytes()); err != nil {
+		return err
+	}
+	if err := p.write(binary.LittleEndian.AppendUint32(nil, uint32(footer.Len()))); err != nil {
+		return err
+	}
+	if err := p.write([]byte("PAR1")); err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+func physicalType(columnType string) int32 {
+	switch columnType {
+	case models.ColumnBool:
+		return parquetBoolean
+	case models.ColumnInt, models.ColumnTime:
+		return parquetInt64
+	}
+	return parquetByteArray
+}
+
+// Thrift compact protocol type ids
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// compact encodes a thrift struct with the compact protocol. Fields must be
+// written in increasing id order; the caller writes the stop byte.
+type compact struct {
+	b    *bytes.Buffer
+	last int16
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (c *compact) varint(v uint64) {
+	c.b.Write(binary.AppendUvarint(nil, v))
+}
+
+func (c *compact) field(id int16, typ byte) {
+	if delta := id - c.last; delta > 0 && delta <= 15 {
+		c.b.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.b.WriteByte(typ)
+		c.varint(zigzag(int64(id)))
+	}
+	c.last = id
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, thriftI32)
+	c.varint(zigzag(int64(v)))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, thriftI64)
+	c.varint(zigzag(v))
+}
+
+func (c *compact) str(id int16, s string) {
+	c.field(id, thriftBinary)
+	c.varint(uint64(len(s)))
+	c.b.WriteString(s)
+}
+
+func (c *compact) structField(id int16, fn func(c *compact)) {
+	c.field(id, thriftStruct)
+	c.element(fn)
+}
+
+// list writes a list header; the caller then writes n elements
+func (c *compact) list(id int16, elemType byte, n int) {
+	c.field(id, thriftList)
+	if n < 15 {
+		c.b.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		c.b.WriteByte(0xf0 | elemType)
+		c.varint(uint64(n))
+	}
+}
+
+// element writes a nested struct (a struct field's value or a list element)
+func (c *compact) element(fn func(c *compact)) {
+	fn(&compact{b: c.b})
+	c.b.WriteByte(0)
+}
@@ -2,6 +2,8 @@ module gogol_analytics
 
 go 1.25.4
 
+require github.com/mattn/go-sqlite3 v1.14.32
+
 require (
 	github.com/chromedp/cdproto v0.0.0-20250724212937-08a3db8b4327 // indirect
 	github.com/chromedp/chromedp v0.14.2 // indirect
@@ -10,6 +12,5 @@ require (
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/gobwas/ws v1.4.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.32 // indirect
 	golang.org/x/sys v0.34.0 // indirect
 )
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"gogol_analytics/database"
+	"gogol_analytics/logimport"
+	"gogol_analytics/models"
+	"gogol_analytics/statsimport"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// importLogs implements "gogol import-logs": it stores the page views of web server
+// access logs (plain or gzipped, "-" for stdin) with their original timestamps
+func importLogs(args []string) int {
+	fs := flag.NewFlagSet("import-logs", flag.ExitOnError)
+	site := fs.String("site", "", "website ID; required when the log format has no $host")
+	format := fs.String("format", "combined", `"combined", "common" or an nginx log_format string`)
+	until := fs.String("until", "", "skip lines logged on or after this date (YYYY-MM-DD or RFC 3339), e.g. when tracking started")
+	batchSize := fs.Int("batch", 1000, "events per transaction")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gogol import-logs [flags] FILE...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if *batchSize < 1 {
+		fmt.Println("Invalid -batch: must be at least 1")
+		return 2
+	}
+
+	f, err := logimport.ParseFormat(*format)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	conv := &logimport.Converter{Format: f}
+	if *until != "" {
+		if conv.Until, err = parseDate(*until); err != nil {
+			fmt.Printf("Invalid -until: %v\n", err)
+			return 2
+		}
+	}
+
+	database.InitDB()
+	if conv.Websites, err = importWebsites(*site); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	var total logimport.Stats
+	for _, name := range fs.Args() {
+		stats, err := importLogFile(conv, name, *batchSize)
+		fmt.Printf("%s: %d lines, %d page views imported, %d filtered, %d unmatched, %d invalid\n",
+			name, stats.Lines, stats.Imported, stats.Filtered, stats.Unmatched, stats.Invalid)
+		if err != nil {
+			fmt.Printf("Import of %s stopped: %v\n", name, err)
+			return 1
+		}
+		total.Imported += stats.Imported
+	}
+	fmt.Printf("Imported %d page views\n", total.Imported)
+	return 0
+}
+
+func importLogFile(conv *logimport.Converter, name string, batchSize int) (logimport.Stats, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return logimport.Stats{}, err
+		}
+		defer file.Close()
+		r = file
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				return logimport.Stats{}, err
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+	return conv.Import(r, batchSize, database.InsertEvents)
+}
+
+// importWebsites returns the website with the given ID, or all websites
+func importWebsites(id string) ([]models.Website, error) {
+	if id == "" {
+		websites, err := database.GetWebsites()
+		if err == nil && len(websites) == 0 {
+			err = fmt.Errorf("no website configured; add one on the Settings page first")
+		}
+		return websites, err
+	}
+	website, ok, err := database.GetWebsite(id)
+	if err == nil && !ok {
+		err = fmt.Errorf("unknown website %q", id)
+	}
+	return []models.Website{website}, err
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// importStats implements "gogol import-stats": it stores the daily aggregates of
+// Google Analytics, Plausible or Matomo CSV exports for a website
+func importStats(args []string) int {
+	fs := flag.NewFlagSet("import-stats", flag.ExitOnError)
+	site := fs.String("site", "", "website ID (required)")
+	tool := fs.String("tool", "", `exporting tool: "ga", "plausible" or "matomo" (required)`)
+	dimension := fs.String("type", "", `"visitors", "pages", "sources" or "countries"; guessed from the file name when omitted`)
+	date := fs.String("date", "", "day (YYYY-MM-DD) covered by exports without a date column")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gogol import-stats -site SITE_ID -tool TOOL [flags] FILE.csv...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *site == "" || !slices.Contains(statsimport.Tools, *tool) {
+		fs.Usage()
+		return 2
+	}
+	if *date != "" {
+		if _, err := time.Parse("2006-01-02", *date); err != nil {
+			fmt.Printf("Invalid -date: %v\n", err)
+			return 2
+		}
+	}
+
+	database.InitDB()
+	website, ok, err := database.GetWebsite(*site)
+	if err != nil || !ok {
+		fmt.Printf("Unknown website %q %v\n", *site, err)
+		return 1
+	}
+
+	for _, name := range fs.Args() {
+		dim := *dimension
+		if dim == "" {
+			dim = guessDimension(name)
+		}
+		if dim == "" {
+			fmt.Printf("%s: cannot tell what the file contains; pass -type\n", name)
+			return 2
+		}
+
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		stats, err := statsimport.Read(file, statsimport.Options{Tool: *tool, Dimension: dim, Website: website, Date: *date})
+		file.Close()
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			return 1
+		}
+		if err := database.SaveImportedStats(stats); err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			return 1
+		}
+		fmt.Printf("%s: %d daily %s rows imported\n", name, len(stats), dim)
+	}
+	return 0
+}
+
+// guessDimension recognizes the usual export file names, e.g. Plausible's
+// imported_visitors.csv or imported_locations.csv
+func guessDimension(name string) string {
+	base := strings.ToLower(filepath.Base(name))
+	switch {
+	case strings.Contains(base, "visitor"), strings.Contains(base, "overview"):
+		return models.ImportedVisitors
+	case strings.Contains(base, "page"):
+		return models.ImportedPages
+	case strings.Contains(base, "source"), strings.Contains(base, "referr"):
+		return models.ImportedSources
+	case strings.Contains(base, "countr"), strings.Contains(base, "location"):
+		return models.ImportedCountries
+	}
+	return ""
+}
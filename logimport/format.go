@@ -0,0 +1,218 @@
+// Package logimport turns web server access log lines into Gogol events.
+//
+// Log formats are written with nginx log_format variables. The Common and
+// Combined presets cover the default nginx and Apache formats; custom formats
+// may use any of the variables listed in Variables.
+package logimport
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preset log formats
+const (
+	Common   = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`
+	Combined = Common + ` "$http_referer" "$http_user_agent"`
+)
+
+// Variables lists the log format variables the parser understands.
+// Any other $variable is matched and ignored.
+var Variables = []string{
+	"$remote_addr", "$http_x_forwarded_for", "$time_local", "$time_iso8601", "$msec",
+	"$request", "$request_method", "$request_uri", "$uri", "$args", "$status",
+	"$http_referer", "$http_user_agent", "$http_accept_language", "$host", "$http_host", "$scheme",
+}
+
+// Entry is a parsed access log line
+type Entry struct {
+	IP             string
+	Time           time.Time
+	Method         string
+	URI            string // Path and query string
+	Status         int
+	Referrer       string
+	UserAgent      string
+	AcceptLanguage string
+	Host           string // Empty unless the format logs it
+	Scheme         string // Empty unless the format logs it
+}
+
+// Format parses lines written with one log format
+type Format struct {
+	re     *regexp.Regexp
+	fields []string // Variable of each capture group
+}
+
+var variablePattern = regexp.MustCompile(`\$[a-z0-9_]+`)
+
+// ParseFormat compiles a log format. "common" and "combined" select the presets.
+func ParseFormat(format string) (*Format, error) {
+	switch strings.ToLower(format) {
+	case "", "combined":
+		format = Combined
+	case "common", "clf":
+		format = Common
+	}
+
+	f := &Format{}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range variablePattern.FindAllStringIndex(format, -1) {
+		pattern.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+
+		// The character before the variable decides what it may contain
+		var group string
+		switch {
+		case loc[0] > 0 && format[loc[0]-1] == '"':
+			group = `((?:[^"\\]|\\.)*)`
+		case loc[0] > 0 && format[loc[0]-1] == '[':
+			group = `([^\]]*)`
+		default:
+			group = `(\S*)`
+		}
+		pattern.WriteString(group)
+		f.fields = append(f.fields, format[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]))
+	pattern.WriteString(`\s*$`)
+
+	if len(f.fields) == 0 {
+		return nil, fmt.Errorf("logimport: format %q has no variables", format)
+	}
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+	f.re = re
+	return f, nil
+}
+
+// Parse reads one log line
+func (f *Format) Parse(line string) (Entry, error) {
+	m := f.re.FindStringSubmatch(line)
+	if m == nil {
+		return Entry{}, fmt.Errorf("line does not match the log format")
+	}
+
+	var e Entry
+	var path, args string
+	for i, field := range f.fields {
+		value := unescape(m[i+1])
+		if value == "-" {
+			value = ""
+		}
+		switch field {
+		case "$remote_addr":
+			if e.IP == "" {
+				e.IP = value
+			}
+		case "$http_x_forwarded_for":
+			// The first address is the client
+			if first := strings.TrimSpace(strings.Split(value, ",")[0]); first != "" {
+				e.IP = first
+			}
+		case "$time_local":
+			t, err := time.Parse("02/Jan/2006:15:04:05 -0700", value)
+			if err != nil {
+				return e, fmt.Errorf("invalid time %q", value)
+			}
+			e.Time = t
+		case "$time_iso8601":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return e, fmt.Errorf("invalid time %q", value)
+			}
+			e.Time = t
+		case "$msec":
+			sec, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return e, fmt.Errorf("invalid time %q", value)
+			}
+			e.Time = time.UnixMilli(int64(sec * 1000))
+		case "$request":
+			// "GET /path?query HTTP/1.1"
+			parts := strings.Fields(value)
+			if len(parts) >= 2 {
+				e.Method, e.URI = parts[0], parts[1]
+			}
+		case "$request_method":
+			e.Method = value
+		case "$request_uri":
+			e.URI = value
+		case "$uri":
+			path = value
+		case "$args":
+			args = value
+		case "$status":
+			e.Status, _ = strconv.Atoi(value)
+		case "$http_referer":
+			e.Referrer = value
+		case "$http_user_agent":
+			e.UserAgent = value
+		case "$http_accept_language":
+			e.AcceptLanguage = value
+		case "$host", "$http_host":
+			e.Host = value
+		case "$scheme":
+			e.Scheme = value
+		}
+	}
+	if e.URI == "" && path != "" {
+		e.URI = path
+		if args != "" {
+			e.URI += "?" + args
+		}
+	}
+
+	if e.Time.IsZero() {
+		return e, fmt.Errorf("line has no time")
+	}
+	if e.URI == "" {
+		return e, fmt.Errorf("line has no request")
+	}
+	return e, nil
+}
+
+// URL returns the absolute URL of the request, using the logged host and scheme
+// when present and the website's otherwise
+func (e Entry) URL(site *url.URL) string {
+	scheme, host := e.Scheme, e.Host
+	if scheme == "" {
+		scheme = site.Scheme
+	}
+	if host == "" {
+		host = site.Host
+	}
+	return scheme + "://" + host + e.URI
+}
+
+// unescape decodes the \xHH and \" escapes nginx and Apache write in quoted fields
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == 'x' && i+3 < len(s) {
+				if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+					i += 3
+					continue
+				}
+			}
+			b.WriteByte(s[i+1])
+			i++
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package logimport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCombined(t *testing.T) {
+	f, err := ParseFormat("combined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := f.Parse(`203.0.113.7 - frank [10/Oct/2023:13:55:36 -0700] "GET /docs/?q=1 HTTP/1.1" 200 2326 "https://example.org/" "Mozilla/5.0 \"x\""`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entry{
+		IP:        "203.0.113.7",
+		Time:      time.Date(2023, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		Method:    "GET",
+		URI:       "/docs/?q=1",
+		Status:    200,
+		Referrer:  "https://example.org/",
+		UserAgent: `Mozilla/5.0 "x"`,
+	}
+	if !e.Time.Equal(want.Time) {
+		t.Errorf("time = %v, want %v", e.Time, want.Time)
+	}
+	e.Time = want.Time
+	if e != want {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+}
+
+func TestParseCustom(t *testing.T) {
+	f, err := ParseFormat(`$host $http_x_forwarded_for [$time_iso8601] "$request_method $uri?$args" $status "$http_user_agent"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := f.Parse(`example.com 198.51.100.1 [2024-01-02T03:04:05+00:00] "GET /a?b=c" 200 "curl/8.0"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Host != "example.com" || e.IP != "198.51.100.1" || e.URI != "/a?b=c" || e.Status != 200 || e.UserAgent != "curl/8.0" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	if _, err := f.Parse("not a log line"); err == nil {
+		t.Error("expected an error for a line that does not match")
+	}
+}
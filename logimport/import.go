@@ -0,0 +1,128 @@
+package logimport
+
+import (
+	"bufio"
+	"fmt"
+	"gogol_analytics/controllers"
+	"gogol_analytics/models"
+	"gogol_analytics/tracking"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Converter turns log entries into events, applying the same filters and
+// enrichment whether lines are imported or tailed
+type Converter struct {
+	Format   *Format
+	Websites []models.Website // Lines are matched to a website by domain, like tracker.js hits
+	Exclude  []string         // Paths skipped besides static assets; defaults to tracking.DefaultExcludePaths
+	Until    time.Time        // When set, lines logged at or after Until are skipped
+}
+
+// Stats counts what happened to the lines read
+type Stats struct {
+	Lines     int // Lines read
+	Imported  int // Page views stored
+	Filtered  int // Not a successful GET of a page (assets, errors, redirects, ...)
+	Unmatched int // Domain of no configured website
+	Invalid   int // Not in the log format
+}
+
+// Event parses a log line and returns its page view event. ok is false when the
+// line is skipped; the reason is counted in stats.
+func (c *Converter) Event(line string, stats *Stats) (event models.Event, ok bool) {
+	stats.Lines++
+	entry, err := c.Format.Parse(line)
+	if err != nil {
+		stats.Invalid++
+		return event, false
+	}
+
+	exclude := c.Exclude
+	if exclude == nil {
+		exclude = tracking.DefaultExcludePaths
+	}
+	u, err := url.ParseRequestURI(entry.URI)
+	if err != nil || entry.Method != http.MethodGet || entry.Status != http.StatusOK ||
+		tracking.IsExcluded(u.Path, exclude) || (!c.Until.IsZero() && !entry.Time.Before(c.Until)) {
+		stats.Filtered++
+		return event, false
+	}
+
+	website, pageURL, ok := c.match(entry)
+	if !ok {
+		stats.Unmatched++
+		return event, false
+	}
+
+	event = controllers.NewServerEvent(website, controllers.ServerHit{
+		URL:            pageURL,
+		Referrer:       entry.Referrer,
+		IP:             entry.IP,
+		UserAgent:      entry.UserAgent,
+		AcceptLanguage: entry.AcceptLanguage,
+		Timestamp:      entry.Time.Local(),
+	})
+	event.SourceType = models.SourceLog
+	return event, true
+}
+
+// match finds the website of the entry: by the logged host when there is one,
+// else the single website of the converter
+func (c *Converter) match(entry Entry) (models.Website, string, bool) {
+	if entry.Host == "" && len(c.Websites) != 1 {
+		return models.Website{}, "", false
+	}
+	for _, website := range c.Websites {
+		site, err := url.Parse(website.URL)
+		if err != nil {
+			continue
+		}
+		if site.Scheme == "" {
+			site.Scheme = "https"
+		}
+		pageURL := entry.URL(site)
+		if w, ok := controllers.MatchWebsite([]models.Website{website}, pageURL); ok {
+			return w, pageURL, true
+		}
+	}
+	return models.Website{}, "", false
+}
+
+// Import reads a whole log and stores its page views in batches of batchSize events
+func (c *Converter) Import(r io.Reader, batchSize int, store func([]models.Event) error) (Stats, error) {
+	var stats Stats
+	if batchSize < 1 {
+		return stats, fmt.Errorf("batch size must be at least 1, got %d", batchSize)
+	}
+	batch := make([]models.Event, 0, batchSize)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		event, ok := c.Event(scanner.Text(), &stats)
+		if !ok {
+			continue
+		}
+		batch = append(batch, event)
+		if len(batch) >= batchSize {
+			if err := store(batch); err != nil {
+				return stats, err
+			}
+			stats.Imported += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+	if len(batch) > 0 {
+		if err := store(batch); err != nil {
+			return stats, err
+		}
+		stats.Imported += len(batch)
+	}
+	return stats, nil
+}
@@ -0,0 +1,120 @@
+package logimport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Follow reads the lines appended to the file at path until ctx is done, like
+// "tail -F": it starts at the current end of the file, reopens the path when the
+// file is rotated (renamed or replaced) and starts over when it is truncated.
+// A file that does not exist yet is waited for. handle is called for each complete line.
+func Follow(ctx context.Context, path string, poll time.Duration, handle func(line string)) error {
+	var (
+		file    *os.File
+		info    os.FileInfo
+		offset  int64
+		partial []byte
+		buf     = make([]byte, 64<<10)
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// drain reads everything appended since the last call
+	drain := func() error {
+		for {
+			n, err := file.ReadAt(buf, offset)
+			if n > 0 {
+				offset += int64(n)
+				partial = append(partial, buf[:n]...)
+				for {
+					i := bytes.IndexByte(partial, '\n')
+					if i < 0 {
+						break
+					}
+					handle(string(bytes.TrimRight(partial[:i], "\r")))
+					partial = partial[i+1:]
+				}
+			}
+			if err == io.EOF || (err == nil && n == 0) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	open := func(atEnd bool) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		file, info, offset, partial = f, fi, 0, nil
+		if atEnd {
+			offset = fi.Size()
+		}
+		return nil
+	}
+
+	// Lines logged before the server started are left to import-logs
+	if err := open(true); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if file == nil {
+			// Created after startup (or after a rotation without a new file yet): read it all
+			if err := open(false); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+		}
+
+		current, err := os.Stat(path)
+		switch {
+		case err == nil && !os.SameFile(info, current):
+			// Rotated: finish the old file, then follow the new one from its start
+			if err := drain(); err != nil {
+				return err
+			}
+			file.Close()
+			file = nil
+			if err := open(false); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		case err == nil && current.Size() < offset:
+			// Truncated in place (copytruncate)
+			offset, partial = 0, nil
+		case err != nil && !os.IsNotExist(err):
+			return err
+		}
+
+		if file != nil {
+			if err := drain(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -1,17 +1,38 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gogol_analytics/controllers"
 	"gogol_analytics/database"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // Website time zones work on hosts without zoneinfo files
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import-logs":
+			os.Exit(importLogs(os.Args[2:]))
+		case "import-stats":
+			os.Exit(importStats(os.Args[2:]))
+		case "export":
+			os.Exit(exportEvents(os.Args[2:]))
+		}
+	}
+
 	// Initialize Database
 	database.InitDB()
 
+	// Background writer for ingested events
+	database.StartWriter(database.DefaultWriterConfig)
+
 	// Static file server
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
@@ -19,17 +40,55 @@ func main() {
 	// Routes
 	http.HandleFunc("/", controllers.Traffic)
 	http.HandleFunc("/conversions", controllers.Conversions)
+	http.HandleFunc("/performance", controllers.Performance)
+	http.HandleFunc("/errors", controllers.Errors)
 	http.HandleFunc("/settings", controllers.Settings)
 	http.HandleFunc("/settings/add", controllers.SettingsAdd)
 	http.HandleFunc("/settings/delete", controllers.SettingsDelete)
+	http.HandleFunc("/settings/public-url", controllers.SettingsPublicURL)
+	http.HandleFunc("/settings/identity", controllers.SettingsIdentity)
+	http.HandleFunc("/settings/timezone", controllers.SettingsTimeZone)
+	http.HandleFunc("/settings/url-rules", controllers.SettingsURLRules)
+	http.HandleFunc("/settings/api-key", controllers.SettingsAPIKey)
 	http.HandleFunc("/api/events", controllers.Events)
 	http.HandleFunc("/api/track", controllers.Track)
 	http.HandleFunc("/api/track-noscript", controllers.TrackNoscript)
+	http.HandleFunc("/api/vitals", controllers.Vitals)
+	http.HandleFunc("/api/errors", controllers.JSErrors)
+	http.HandleFunc("/api/server/track", controllers.ServerTrack)
+	http.HandleFunc("/api/export", controllers.ExportEvents)
+	http.HandleFunc("/api/v1/timeseries", controllers.APITimeseries)
+	http.HandleFunc("/api/v1/top", controllers.APITop)
+	http.HandleFunc("/api/v1/realtime", controllers.APIRealtime)
+	http.HandleFunc("/api/v1/events", controllers.APIEvents)
+	http.HandleFunc("/api/health", controllers.Health)
+
+	// Listen address; set GOGOL_ADDR=:8091 to accept connections from other hosts
+	addr := os.Getenv("GOGOL_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8091"
+	}
+	server := &http.Server{Addr: addr}
 
-	fmt.Println("Server starting on http://localhost:8091")
-	if err := http.ListenAndServe("127.0.0.1:8091", nil); err != nil {
+	// Flush queued events before exiting on Ctrl+C / SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	// Optional access log tailing (GOGOL_TAIL_LOG)
+	if err := startLogTail(ctx); err != nil {
+		fmt.Printf("Log tailing disabled: %v\n", err)
+	}
+
+	fmt.Printf("Server starting on %s\n", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		fmt.Printf("Server failed: %v\n", err)
 		log.Fatal(err)
 	}
-	fmt.Println("Server exited unexpectedly")
+
+	database.StopWriter()
+	fmt.Println("Server stopped, pending events flushed")
 }
@@ -1,22 +1,74 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Visitor identity modes stored in Website.IdentityMode
+const (
+	IdentityHash   = "hash"   // sha256(IP + UserAgent), no client-side storage
+	IdentityCookie = "cookie" // Random first-party ID kept by tracker.js (requires consent)
+)
+
+// NormalizationRules control how page URLs of a website are cleaned at ingestion
+type NormalizationRules struct {
+	StripParams       []string // Query parameters removed from stored URLs (e.g. session tokens)
+	StripAllParams    bool     // Remove every query parameter except AllowParams
+	AllowParams       []string // Parameters that are part of the page identity (kept in NormalizedPath)
+	FoldTrailingSlash bool     // "/page/" and "/page" are the same page
+	FoldCase          bool     // "/PAGE" and "/page" are the same page
+	HashRoutes        bool     // "#/route" fragments are the page path (single-page apps)
+}
+
+// DefaultNormalizationRules apply to websites that have not configured their own
+var DefaultNormalizationRules = NormalizationRules{FoldTrailingSlash: true}
 
 // Website represents a tracked website
 type Website struct {
-	ID        string
-	Name      string
-	URL       string
-	CreatedAt time.Time
+	ID            string
+	Name          string
+	URL           string
+	CreatedAt     time.Time
+	IdentityMode  string
+	Normalization NormalizationRules
+	APIKey        string // Authenticates server-side tracking; empty until generated
+	TimeZone      string // IANA name ("America/New_York") of report days; empty for the server's zone
+}
+
+// Location returns the time zone that splits the website's reports into days
+func (w Website) Location() *time.Location {
+	if w.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// TimeZones are suggested by the time zone inputs; any IANA name is accepted
+var TimeZones = []string{
+	"UTC",
+	"America/Los_Angeles", "America/Denver", "America/Chicago", "America/New_York",
+	"America/Sao_Paulo", "Europe/London", "Europe/Paris", "Europe/Berlin", "Europe/Moscow",
+	"Africa/Johannesburg", "Asia/Dubai", "Asia/Kolkata", "Asia/Singapore", "Asia/Shanghai",
+	"Asia/Tokyo", "Australia/Sydney", "Pacific/Auckland",
 }
 
 // ChartDataPoint represents a single point in the traffic chart
 type ChartDataPoint struct {
-	Label             string // Timestamp or Date
+	Time              time.Time // Start of the bucket
+	Label             string    // Timestamp or Date
 	Views             int
-	NewVisitors       int
-	ReturningVisitors int
+	Visitors          int // Distinct human visitors of the bucket
+	NewVisitors       int // Visitors whose first visit to the website falls in the bucket
+	ReturningVisitors int // Visitors first seen before the bucket
 	Bots              int
+	ImportedVisitors  int // Visitors imported from another tool, for days before tracking
 }
 
 // TableRow represents a row in the analytics tables
@@ -24,14 +76,61 @@ type TableRow struct {
 	Key        string
 	Value      int
 	Percentage float64 // Optional helper for UI bars
+	Title      string  // Page title, for rows keyed by page path
+	Previous   int     // Value in the comparison period, when comparing
 }
 
+// Change returns the percentage change from the comparison period, e.g. "+12%", or "new"
+func (r TableRow) Change() string { return change(r.Value, r.Previous) }
+
+// Up reports whether the value grew since the comparison period
+func (r TableRow) Up() bool { return r.Value >= r.Previous }
+
+// change formats the evolution of a count for comparison columns
+func change(value, previous int) string {
+	if previous == 0 {
+		if value == 0 {
+			return "0%"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.0f%%", float64(value-previous)*100/float64(previous))
+}
+
+// PageFlowRow is a page in the Entry Pages or Exit Pages table.
+// Sessions counts the sessions that started (entry) or ended (exit) on the page.
+type PageFlowRow struct {
+	Key              string
+	Title            string
+	Sessions         int
+	PreviousSessions int     // Sessions in the comparison period, when comparing
+	Views            int     // Exit pages: all views of the page in the range
+	Bounces          int     // Entry pages: single-page sessions
+	BounceRate       float64 // Entry pages: Bounces / Sessions, in percent
+	ExitRate         float64 // Exit pages: Sessions / Views, in percent
+}
+
+// Change returns the percentage change of Sessions from the comparison period
+func (r PageFlowRow) Change() string { return change(r.Sessions, r.PreviousSessions) }
+
+// Up reports whether Sessions grew since the comparison period
+func (r PageFlowRow) Up() bool { return r.Sessions >= r.PreviousSessions }
+
 // TrafficPageData is the specific data structure passed to the Traffic View
 type TrafficPageData struct {
 	CurrentPage         string
-	TimeRange           string
+	TimeRange           string // A preset ("24h", "month", "all"…) or "custom"
+	From, To            string // Inclusive days (YYYY-MM-DD) of the range
+	RangeLabel          string // Human-readable range, e.g. "Last 7 days"
+	Compare             string // Comparison period ("previous" or "year"), empty when not comparing
 	ChartData           []ChartDataPoint
+	PreviousViews       []int    // Views per chart bucket in the comparison period
+	TotalViews          TableRow // Views of the range, with Previous when comparing
+	TotalVisitors       TableRow // Distinct human visitors of the range, with Previous when comparing
+	HasImported         bool     // Chart includes visitors imported from another tool
 	PageStats           []TableRow
+	EntryPageStats      []PageFlowRow
+	ExitPageStats       []PageFlowRow
 	CountryStats        []TableRow
 	BrowserStats        []TableRow
 	ResolutionStats     []TableRow
@@ -40,16 +139,182 @@ type TrafficPageData struct {
 	KeywordStats        []TableRow
 	DeviceStats         []TableRow
 	OSStats             []TableRow
+	SourceTypeStats     []TableRow
+	LanguageStats       []TableRow // Locales of Language when it is set
+	Language            string     // Language of an equality filter; the Languages table then lists its locales
 	RecentEvents        []Event
+	Filters             []Filter // Applied to every query of the page
+	TimeZone            string   // Viewer's time zone override (tz parameter), empty for the websites' zone
+	Location            string   // Time zone the days and chart buckets are in
+	Site                string   // Website the page is limited to (site parameter), empty for every website
+	Websites            []Website
+}
+
+// TimeZones are suggested by the time zone override input
+func (d TrafficPageData) TimeZones() []string {
+	return TimeZones
+}
+
+// RangePreset is a named time range of the Traffic page
+type RangePreset struct {
+	Key   string // Value of the range parameter
+	Title string
+}
+
+// TrafficRanges lists the range presets offered on the Traffic page
+var TrafficRanges = []RangePreset{
+	{"24h", "Last 24 hours"},
+	{"7d", "Last 7 days"},
+	{"30d", "Last 30 days"},
+	{"month", "This month"},
+	{"last_month", "Last month"},
+	{"ytd", "Year to date"},
+	{"all", "All time"},
+}
+
+// Ranges returns the range presets for the range selector
+func (d TrafficPageData) Ranges() []RangePreset {
+	return TrafficRanges
+}
+
+// Totals returns the headline numbers of the chart
+func (d TrafficPageData) Totals() []TableRow {
+	return []TableRow{d.TotalViews, d.TotalVisitors}
+}
+
+// pageURL returns the Traffic page URL for a range and a set of filters
+func (d TrafficPageData) pageURL(timeRange string, filters []Filter) string {
+	params := url.Values{"range": {timeRange}}
+	if timeRange == "custom" {
+		params.Set("from", d.From)
+		params.Set("to", d.To)
+	}
+	if d.Compare != "" && timeRange != "all" {
+		params.Set("compare", d.Compare)
+	}
+	if d.TimeZone != "" {
+		params.Set("tz", d.TimeZone)
+	}
+	if d.Site != "" {
+		params.Set("site", d.Site)
+	}
+	for _, f := range filters {
+		params.Add("filter", f.String())
+	}
+	return "?" + params.Encode()
+}
+
+// RangeURL returns the page URL for another time range, keeping the filters
+func (d TrafficPageData) RangeURL(timeRange string) string {
+	return d.pageURL(timeRange, d.Filters)
+}
+
+// FilterURL returns the page URL with one more filter: dimension equals value
+func (d TrafficPageData) FilterURL(dimension, value string) string {
+	for _, f := range d.Filters {
+		if f.Dimension == dimension && f.Op == FilterEquals && f.Value == value {
+			return d.pageURL(d.TimeRange, d.Filters)
+		}
+	}
+	filters := append(d.Filters[:len(d.Filters):len(d.Filters)], Filter{Dimension: dimension, Op: FilterEquals, Value: value})
+	return d.pageURL(d.TimeRange, filters)
+}
+
+// ClearDimensionURL returns the page URL without the filters on a dimension
+func (d TrafficPageData) ClearDimensionURL(dimension string) string {
+	var filters []Filter
+	for _, f := range d.Filters {
+		if f.Dimension != dimension {
+			filters = append(filters, f)
+		}
+	}
+	return d.pageURL(d.TimeRange, filters)
+}
+
+// CompareURL returns the page URL with another comparison period ("" to stop comparing)
+func (d TrafficPageData) CompareURL(period string) string {
+	d.Compare = period
+	return d.pageURL(d.TimeRange, d.Filters)
+}
+
+// SiteURL returns the page URL for one website ("" for every website), keeping the filters
+func (d TrafficPageData) SiteURL(site string) string {
+	d.Site = site
+	return d.pageURL(d.TimeRange, d.Filters)
+}
+
+// RemoveFilterURL returns the page URL without the i-th filter
+func (d TrafficPageData) RemoveFilterURL(i int) string {
+	filters := append(d.Filters[:i:i], d.Filters[i+1:]...)
+	return d.pageURL(d.TimeRange, filters)
+}
+
+// Filter operators
+const (
+	FilterEquals    = "eq"
+	FilterNotEquals = "neq"
+	FilterContains  = "contains" // Case-insensitive substring
+	FilterRegex     = "regex"    // Go regular expression (RE2 syntax)
+)
+
+// FilterOps lists the filter operators with the symbol shown on filter chips
+var FilterOps = map[string]string{
+	FilterEquals:    "is",
+	FilterNotEquals: "is not",
+	FilterContains:  "contains",
+	FilterRegex:     "matches",
+}
+
+// Filter restricts statistics to the events whose dimension matches Value
+type Filter struct {
+	Dimension string
+	Op        string // FilterEquals, FilterNotEquals, FilterContains or FilterRegex
+	Value     string
+}
+
+// String returns the filter as written in URLs: "dimension:op:value"
+func (f Filter) String() string {
+	return f.Dimension + ":" + f.Op + ":" + f.Value
+}
+
+// OpLabel returns the words shown for the operator on filter chips
+func (f Filter) OpLabel() string {
+	return FilterOps[f.Op]
 }
 
 // SettingsPageData is data for the settings page
 type SettingsPageData struct {
 	CurrentPage string
 	Websites    []Website
+	BaseURL     string // Effective public URL of this server, used in every snippet
+	PublicURL   string // Value saved in settings (empty when derived from env or request)
 	ScriptURL   string
 }
 
+// TimeZones are suggested by the website time zone inputs
+func (d SettingsPageData) TimeZones() []string {
+	return TimeZones
+}
+
+// Tracking methods stored in Event.SourceType
+const (
+	SourceJS       = "js"       // tracker.js
+	SourceNoscript = "noscript" // <noscript> image pixel
+	SourceServer   = "server"   // server-side tracking
+	SourceLog      = "log"      // web server access log (import or tail)
+)
+
+// IsBotUserAgent flags crawlers that announce themselves in the User-Agent
+func IsBotUserAgent(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, marker := range []string{"bot", "crawl", "spider", "slurp", "headless", "curl/", "wget/"} {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+	return false
+}
+
 // Event represents a single traffic event (page view)
 type Event struct {
 	ID               int64     `json:"-"`
@@ -64,10 +329,182 @@ type Event struct {
 	Referrer         string    `json:"referrer"`
 	CurrentURL       string    `json:"current_url"`
 	IsBot            bool      `json:"is_bot"`
+	SourceType       string    `json:"source_type"` // How the hit was collected: SourceJS, SourceNoscript, SourceServer or SourceLog
+	PageTitle        string    `json:"page_title"`
+	NormalizedPath   string    `json:"normalized_path"` // Page identity after the website's normalization rules
+
+	// URL parts stored as columns at ingestion
+	Hostname     string `json:"hostname"`
+	Path         string `json:"path"`
+	QueryString  string `json:"query_string"`
+	ReferrerHost string `json:"referrer_host"`
+	ReferrerPath string `json:"referrer_path"`
 
 	// Derived fields (parsed server-side)
-	OS      string `json:"os"`
-	Browser string `json:"browser"`
-	Device  string `json:"device"`
-	Keyword string `json:"keyword"` // Extracted from referrer if search engine
+	OS       string `json:"os"`
+	Browser  string `json:"browser"`
+	Device   string `json:"device"`
+	Keyword  string `json:"keyword"`  // Extracted from referrer if search engine
+	Language string `json:"language"` // Primary language, e.g. "fr"; the tracker sends navigator.language here
+	Locale   string `json:"locale"`   // Language with script and region, e.g. "fr-CA"
+}
+
+// WebVitals is one page view's Core Web Vitals sample; metrics the browser did not report are nil
+type WebVitals struct {
+	WebsiteID string
+	Timestamp time.Time
+	Path      string // Normalized page path
+	Device    string
+	Browser   string
+	LCP       *float64 `json:"lcp"`  // Largest Contentful Paint, ms
+	CLS       *float64 `json:"cls"`  // Cumulative Layout Shift, unitless
+	INP       *float64 `json:"inp"`  // Interaction to Next Paint, ms
+	FCP       *float64 `json:"fcp"`  // First Contentful Paint, ms
+	TTFB      *float64 `json:"ttfb"` // Time to First Byte, ms
+}
+
+// VitalMetric describes a Core Web Vital and its rating thresholds
+type VitalMetric struct {
+	Name   string  // Short name, also the lowercase column name (e.g. "LCP" -> lcp)
+	Label  string  // Human readable name
+	Unit   string  // "ms" or "" for unitless
+	Good   float64 // Values <= Good are rated good
+	Poor   float64 // Values > Poor are rated poor, anything between needs improvement
+	Digits int     // Decimal places shown in the dashboard
+}
+
+// VitalMetrics lists the collected metrics with the thresholds published on web.dev
+var VitalMetrics = []VitalMetric{
+	{Name: "LCP", Label: "Largest Contentful Paint", Unit: "ms", Good: 2500, Poor: 4000},
+	{Name: "CLS", Label: "Cumulative Layout Shift", Unit: "", Good: 0.1, Poor: 0.25, Digits: 3},
+	{Name: "INP", Label: "Interaction to Next Paint", Unit: "ms", Good: 200, Poor: 500},
+	{Name: "FCP", Label: "First Contentful Paint", Unit: "ms", Good: 1800, Poor: 3000},
+	{Name: "TTFB", Label: "Time to First Byte", Unit: "ms", Good: 800, Poor: 1800},
+}
+
+// VitalSummary aggregates the samples of one metric
+type VitalSummary struct {
+	Samples          int
+	P50, P75, P95    float64
+	Good             int
+	NeedsImprovement int
+	Poor             int
+}
+
+// GoodPct, NeedsImprovementPct and PoorPct give the rating distribution in percent
+func (s VitalSummary) GoodPct() float64             { return pct(s.Good, s.Samples) }
+func (s VitalSummary) NeedsImprovementPct() float64 { return pct(s.NeedsImprovement, s.Samples) }
+func (s VitalSummary) PoorPct() float64             { return pct(s.Poor, s.Samples) }
+
+func pct(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// VitalsRow is one row of a performance breakdown table (a page, device or browser)
+type VitalsRow struct {
+	Key     string
+	Summary VitalSummary
+}
+
+// MetricOverview pairs a metric with its site-wide summary
+type MetricOverview struct {
+	Metric  VitalMetric
+	Summary VitalSummary
+}
+
+// PerformancePageData is the data passed to the Performance view
+type PerformancePageData struct {
+	CurrentPage string
+	TimeRange   string
+	Metric      VitalMetric // Metric shown in the breakdown tables
+	Overview    []MetricOverview
+	ByPage      []VitalsRow
+	ByDevice    []VitalsRow
+	ByBrowser   []VitalsRow
+}
+
+// JSError is a client-side JavaScript error reported by tracker.js
+type JSError struct {
+	WebsiteID   string
+	Timestamp   time.Time
+	Fingerprint string // Groups occurrences of the same error
+	Message     string `json:"message"`
+	Source      string `json:"source"` // Script URL
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
+	Stack       string `json:"stack"`
+	Path        string // Normalized page path
+	Browser     string
+	OS          string
+}
+
+// ErrorGroup aggregates the occurrences of one error fingerprint
+type ErrorGroup struct {
+	Fingerprint string
+	Message     string
+	Source      string
+	Line        int
+	Column      int
+	Stack       string
+	Count       int
+	PageCount   int
+	Pages       []string // Most affected pages first
+	Browsers    []string
+	LastSeen    time.Time
+}
+
+// ErrorsPageData is the data passed to the Errors view
+type ErrorsPageData struct {
+	CurrentPage string
+	TimeRange   string
+	Errors      []ErrorGroup
+}
+
+// CustomEvent is a named action (signup, purchase, ...) reported by a backend
+// through the server-side tracking API
+type CustomEvent struct {
+	WebsiteID string
+	Timestamp time.Time
+	VisitorID string
+	Name      string
+	Path      string            // Normalized path of the page the event happened on
+	Props     map[string]string // Free-form properties, stored as JSON
+}
+
+// Dimensions of imported aggregates
+const (
+	ImportedVisitors  = "visitors"  // Daily totals, empty key
+	ImportedPages     = "pages"     // Key is the normalized path
+	ImportedSources   = "sources"   // Key is the referrer host or "Direct"
+	ImportedCountries = "countries" // Key is the country name
+)
+
+// ImportedStat is a daily aggregate imported from another analytics tool's export.
+// Imported aggregates are kept apart from events and only count for days before
+// the website's first tracked event.
+type ImportedStat struct {
+	WebsiteID string
+	Date      string // YYYY-MM-DD
+	Tool      string // "ga", "plausible" or "matomo"
+	Dimension string
+	Key       string
+	Visitors  int
+	Pageviews int
+}
+
+// Types of exported columns
+const (
+	ColumnString = "string"
+	ColumnInt    = "int"
+	ColumnBool   = "bool"
+	ColumnTime   = "time"
+)
+
+// ExportColumn is an events column available to raw exports
+type ExportColumn struct {
+	Name string
+	Type string // ColumnString, ColumnInt, ColumnBool or ColumnTime
 }
@@ -0,0 +1,88 @@
+// Package proxy serves the Gogol tracker and ingestion endpoints from a customer's own domain.
+//
+// Mounting the handler under a first-party path keeps tracking working when ad blockers
+// or Content-Security-Policy rules block the analytics domain:
+//
+//	h, err := proxy.NewHandler("https://analytics.example.com", "/stats")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	mux.Handle("/stats/", h)
+//
+// The page then embeds:
+//
+//	<script src="/stats/js/tracker.js" data-api="/stats/api"></script>
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// TrackerPath is the location of the tracker script on the Gogol server
+const TrackerPath = "/static/js/tracker.js"
+
+// ingestionPaths are the API endpoints browsers send hits to. The rest of /api/ (exports,
+// statistics, the live event stream) exposes every website's data and is never proxied.
+var ingestionPaths = map[string]bool{
+	"/api/track":          true,
+	"/api/track-noscript": true,
+	"/api/vitals":         true,
+	"/api/errors":         true,
+}
+
+// NewHandler returns an http.Handler that forwards <prefix>/js/tracker.js and the ingestion
+// endpoints <prefix>/api/track, track-noscript, vitals and errors to the Gogol server at target. Every other path under prefix answers 404.
+// The original client address is appended to X-Forwarded-For, which the Gogol server
+// honors for peers listed in GOGOL_TRUSTED_PROXIES.
+func NewHandler(target, prefix string) (http.Handler, error) {
+	upstream, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if upstream.Scheme == "" || upstream.Host == "" {
+		return nil, fmt.Errorf("proxy: target %q must be an absolute URL", target)
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+			r.Out.URL.Path = upstreamPath(r.In.URL.Path, prefix)
+			r.Out.URL.RawPath = ""
+			r.Out.Host = upstream.Host
+			r.SetXForwarded()
+			// Cookies of the customer's site are none of the analytics server's business
+			r.Out.Header.Del("Cookie")
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upstreamPath(r.URL.Path, prefix) == "" {
+			http.NotFound(w, r)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	}), nil
+}
+
+// upstreamPath maps a first-party path to the Gogol server path, or "" if it is not proxied
+func upstreamPath(path, prefix string) string {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return ""
+	}
+	switch {
+	case rest == "/js/tracker.js":
+		return TrackerPath
+	case ingestionPaths[rest]:
+		return rest
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerRewritesPaths(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Path", r.URL.Path)
+		w.Header().Set("X-Seen-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Seen-Cookie", r.Header.Get("Cookie"))
+	}))
+	defer backend.Close()
+
+	h, err := NewHandler(backend.URL, "/stats/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(h)
+	defer front.Close()
+
+	cases := []struct {
+		path   string
+		want   string
+		status int
+	}{
+		{"/stats/js/tracker.js", TrackerPath, http.StatusOK},
+		{"/stats/api/track", "/api/track", http.StatusOK},
+		{"/stats/api/track-noscript", "/api/track-noscript", http.StatusOK},
+		{"/stats/api/vitals", "/api/vitals", http.StatusOK},
+		{"/stats/api/errors", "/api/errors", http.StatusOK},
+		{"/stats/settings", "", http.StatusNotFound},
+		{"/stats/api/export", "", http.StatusNotFound},
+		{"/stats/api/v1/events", "", http.StatusNotFound},
+		{"/stats/api/events", "", http.StatusNotFound},
+		{"/stats/api/server/track", "", http.StatusNotFound},
+		{"/stats/api/health", "", http.StatusNotFound},
+		{"/stats/api/track/../export", "", http.StatusNotFound},
+		{"/other/api/track", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", front.URL+c.path, nil)
+		req.Header.Set("Cookie", "session=secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: status %d, want %d", c.path, resp.StatusCode, c.status)
+			continue
+		}
+		if c.want == "" {
+			continue
+		}
+		if got := resp.Header.Get("X-Seen-Path"); got != c.want {
+			t.Errorf("%s: upstream path %q, want %q", c.path, got, c.want)
+		}
+		if got := resp.Header.Get("X-Seen-For"); got != "127.0.0.1" {
+			t.Errorf("%s: X-Forwarded-For %q, want client IP", c.path, got)
+		}
+		if got := resp.Header.Get("X-Seen-Cookie"); got != "" {
+			t.Errorf("%s: cookie leaked upstream: %q", c.path, got)
+		}
+	}
+}
@@ -1,6 +1,75 @@
 (function () {
     console.log("Gogol Analytics Tracker Loaded");
 
+    // Resolve the API endpoint from the script tag itself so the tracker works on any deployment:
+    // <script src="https://analytics.example.com/static/js/tracker.js" data-api="https://analytics.example.com/api">
+    // Without data-api, the API is assumed to live on the same origin as the script.
+    const script = document.currentScript;
+    let apiBase = '/api';
+    if (script) {
+        if (script.dataset.api) {
+            apiBase = script.dataset.api;
+        } else if (script.src) {
+            apiBase = new URL(script.src).origin + '/api';
+        }
+    }
+    apiBase = apiBase.replace(/\/+$/, '');
+
+    // Opt-in cookie identity (data-identity="cookie"): keep a random first-party visitor ID
+    // in localStorage and a cookie so visitors stay recognizable across networks.
+    // Only enable on sites where visitors consented; the server ignores the ID otherwise.
+    const useCookieIdentity = !!(script && script.dataset.identity === 'cookie');
+    const idKey = '_gogol_id';
+
+    function randomID() {
+        if (window.crypto && crypto.randomUUID) {
+            return crypto.randomUUID();
+        }
+        const bytes = new Uint8Array(16);
+        crypto.getRandomValues(bytes);
+        return Array.from(bytes, b => b.toString(16).padStart(2, '0')).join('');
+    }
+
+    function visitorID() {
+        let id = null;
+        try {
+            id = localStorage.getItem(idKey);
+        } catch (e) {
+            // Storage may be disabled; fall back to the cookie
+        }
+        if (!id) {
+            const match = document.cookie.match(new RegExp('(?:^|; )' + idKey + '=([^;]+)'));
+            id = match ? decodeURIComponent(match[1]) : randomID();
+        }
+        try {
+            localStorage.setItem(idKey, id);
+        } catch (e) {
+            // Ignore, the cookie below is enough
+        }
+        document.cookie = idKey + '=' + encodeURIComponent(id) + '; max-age=31536000; path=/; SameSite=Lax' +
+            (location.protocol === 'https:' ? '; Secure' : '');
+        return id;
+    }
+
+    // Send a payload without triggering a CORS preflight and without being cancelled on unload:
+    // sendBeacon with a text/plain body, or a keepalive fetch where beacons are unavailable or refused
+    function send(path, payload) {
+        const url = apiBase + path;
+        const body = JSON.stringify(payload);
+        if (navigator.sendBeacon && navigator.sendBeacon(url, new Blob([body], { type: 'text/plain' }))) {
+            return Promise.resolve();
+        }
+        return fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'text/plain'
+            },
+            body: body,
+            keepalive: true,
+            credentials: 'omit'
+        });
+    }
+
     async function collectAndSend() {
         try {
             // 1. Fetch Location & Proxy Info
@@ -17,6 +86,8 @@
             const screenRes = `${window.screen.width}x${window.screen.height}`;
             const referrer = document.referrer;
             const currentUrl = window.location.href;
+            const pageTitle = document.title;
+            const language = navigator.language || '';
 
             // 3. Bot Detection
             let isBot = false;
@@ -36,17 +107,16 @@
                 screen_resolution: screenRes,
                 referrer: referrer,
                 current_url: currentUrl,
+                page_title: pageTitle,
+                language: language,
                 is_bot: isBot
             };
+            if (useCookieIdentity) {
+                payload.visitor_id = visitorID();
+            }
 
             // 5. Send to Backend
-            await fetch('http://localhost:8091/api/track', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json'
-                },
-                body: JSON.stringify(payload)
-            });
+            await send('/track', payload);
 
             console.log("Analytics data sent successfully", payload);
 
@@ -55,6 +125,131 @@
         }
     }
 
+    // Core Web Vitals (opt out with data-vitals="false"), measured with PerformanceObserver
+    // and sent once when the page is hidden, since LCP, CLS and INP keep changing until then
+    function collectVitals() {
+        if (!('PerformanceObserver' in window)) {
+            return;
+        }
+
+        const vitals = {};
+
+        function observe(type, callback, options) {
+            try {
+                const observer = new PerformanceObserver(list => list.getEntries().forEach(callback));
+                observer.observe(Object.assign({ type: type, buffered: true }, options));
+            } catch (e) {
+                // Entry type not supported by this browser
+            }
+        }
+
+        observe('largest-contentful-paint', entry => {
+            vitals.lcp = entry.startTime;
+        });
+
+        observe('paint', entry => {
+            if (entry.name === 'first-contentful-paint') {
+                vitals.fcp = entry.startTime;
+            }
+        });
+
+        // CLS: largest burst of layout shifts (session window: gaps < 1s, at most 5s long)
+        let sessionValue = 0;
+        let sessionStart = 0;
+        let sessionLast = 0;
+        observe('layout-shift', entry => {
+            if (entry.hadRecentInput) {
+                return;
+            }
+            if (sessionValue && entry.startTime - sessionLast < 1000 && entry.startTime - sessionStart < 5000) {
+                sessionValue += entry.value;
+            } else {
+                sessionValue = entry.value;
+                sessionStart = entry.startTime;
+            }
+            sessionLast = entry.startTime;
+            vitals.cls = Math.max(vitals.cls || 0, sessionValue);
+        });
+
+        // INP: slowest interaction (close to the p98 used by Chrome for typical pages)
+        const trackInteraction = entry => {
+            if (entry.interactionId || entry.entryType === 'first-input') {
+                vitals.inp = Math.max(vitals.inp || 0, entry.duration);
+            }
+        };
+        observe('event', trackInteraction, { durationThreshold: 16 });
+        observe('first-input', trackInteraction);
+
+        const navigation = performance.getEntriesByType && performance.getEntriesByType('navigation')[0];
+        if (navigation && navigation.responseStart > 0) {
+            vitals.ttfb = navigation.responseStart;
+        }
+
+        let sent = false;
+        function sendVitals() {
+            if (sent || Object.keys(vitals).length === 0) {
+                return;
+            }
+            sent = true;
+            send('/vitals', Object.assign({
+                current_url: window.location.href,
+                user_agent: navigator.userAgent
+            }, vitals)).catch(() => {});
+        }
+        document.addEventListener('visibilitychange', () => {
+            if (document.visibilityState === 'hidden') {
+                sendVitals();
+            }
+        });
+        window.addEventListener('pagehide', sendVitals);
+    }
+
+    if (!(script && script.dataset.vitals === 'false')) {
+        collectVitals();
+    }
+
+    // JavaScript errors (opt in with data-errors="true"): uncaught exceptions and unhandled
+    // promise rejections, at most maxErrors reports per page and each distinct error once
+    function collectErrors() {
+        const maxErrors = 10;
+        const reported = new Set();
+
+        function report(message, source, line, column, stack) {
+            const key = message + '|' + source + '|' + line + '|' + column;
+            if (reported.size >= maxErrors || reported.has(key)) {
+                return;
+            }
+            reported.add(key);
+            send('/errors', {
+                message: String(message).slice(0, 1000),
+                source: source || '',
+                line: line || 0,
+                column: column || 0,
+                stack: stack ? String(stack).slice(0, 8000) : '',
+                current_url: window.location.href,
+                user_agent: navigator.userAgent
+            }).catch(() => {});
+        }
+
+        window.addEventListener('error', event => {
+            // Resource loading failures (img, script) have no message; only report script errors
+            if (!event.message) {
+                return;
+            }
+            report(event.message, event.filename, event.lineno, event.colno, event.error && event.error.stack);
+        });
+
+        window.addEventListener('unhandledrejection', event => {
+            const reason = event.reason;
+            const message = reason instanceof Error ? reason.message : String(reason);
+            report('Unhandled rejection: ' + message, '', 0, 0, reason && reason.stack);
+        });
+    }
+
+    if (script && script.dataset.errors === 'true') {
+        collectErrors();
+    }
+
     // Execute when DOM is ready
     if (document.readyState === 'loading') {
         document.addEventListener('DOMContentLoaded', collectAndSend);
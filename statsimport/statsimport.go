@@ -0,0 +1,235 @@
+// Package statsimport reads the CSV exports of other analytics tools (Google
+// Analytics, Plausible, Matomo) into daily aggregates kept apart from native events.
+package statsimport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"gogol_analytics/models"
+	"gogol_analytics/urlnorm"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tools whose exports are understood
+var Tools = []string{"ga", "plausible", "matomo"}
+
+// columnAliases lists, per field, the normalized header names used by the tools'
+// exports (lowercase, letters and digits only)
+var columnAliases = map[string][]string{
+	"date":      {"date", "day", "dayindex", "period"},
+	"visitors":  {"visitors", "users", "totalusers", "activeusers", "uniquevisitors", "nbuniqvisitors", "sumdailynbuniqvisitors"},
+	"pageviews": {"pageviews", "views", "screenpageviews", "nbpageviews", "nbhits", "actions", "nbactions"},
+	models.ImportedPages: {"page", "pagepath", "pagepathandscreenclass", "pagepathplusquerystring", "pagepathandquerystring",
+		"pathname", "url", "label"},
+	models.ImportedSources:   {"source", "sessionsource", "firstusersource", "referrer", "referrername", "label"},
+	models.ImportedCountries: {"country", "countrycode", "countryname", "countryid", "label"},
+}
+
+// dateLayouts are the date formats found in the exports (ISO, GA4's 20060102, US style)
+var dateLayouts = []string{"2006-01-02", "20060102", "1/2/2006", "1/2/06", "Jan 2, 2006", "January 2, 2006"}
+
+// Options describe one CSV file
+type Options struct {
+	Tool      string         // "ga", "plausible" or "matomo"
+	Dimension string         // models.ImportedVisitors, ImportedPages, ImportedSources or ImportedCountries
+	Website   models.Website // Pages are normalized with the website's URL rules
+	Date      string         // YYYY-MM-DD used when the file has no date column (period exports)
+}
+
+// Read parses a CSV export into daily aggregates. Rows for the same date and key
+// are summed.
+func Read(r io.Reader, opts Options) ([]models.ImportedStat, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#' // GA4 exports start with "# ----" comment lines
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := mapColumns(header)
+
+	if _, ok := columns["date"]; !ok && opts.Date == "" {
+		return nil, fmt.Errorf("the file has no date column; pass the day it covers")
+	}
+	if _, ok := columns[opts.Dimension]; !ok && opts.Dimension != models.ImportedVisitors {
+		return nil, fmt.Errorf("no %s column in header %q", opts.Dimension, header)
+	}
+	_, hasVisitors := columns["visitors"]
+	_, hasPageviews := columns["pageviews"]
+	if !hasVisitors && !hasPageviews {
+		return nil, fmt.Errorf("no visitors or pageviews column in header %q", header)
+	}
+
+	type aggregateKey struct{ date, key string }
+	totals := map[aggregateKey]*models.ImportedStat{}
+	var order []aggregateKey
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// GA exports append further tables (totals, other dimensions) after the first one
+		if len(record) != len(header) {
+			break
+		}
+		if isTotalsRow(record) {
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		date := opts.Date
+		if raw := field("date"); raw != "" {
+			if date, err = parseDate(raw); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		key := ""
+		if opts.Dimension != models.ImportedVisitors {
+			key = normalizeKey(opts.Dimension, field(opts.Dimension), opts.Website)
+			if key == "" {
+				continue
+			}
+		}
+
+		visitors, err := parseCount(field("visitors"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		pageviews, err := parseCount(field("pageviews"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		k := aggregateKey{date, key}
+		stat, ok := totals[k]
+		if !ok {
+			stat = &models.ImportedStat{
+				WebsiteID: opts.Website.ID,
+				Date:      date,
+				Tool:      opts.Tool,
+				Dimension: opts.Dimension,
+				Key:       key,
+			}
+			totals[k] = stat
+			order = append(order, k)
+		}
+		stat.Visitors += visitors
+		stat.Pageviews += pageviews
+	}
+
+	stats := make([]models.ImportedStat, 0, len(order))
+	for _, k := range order {
+		stats = append(stats, *totals[k])
+	}
+	return stats, nil
+}
+
+// isTotalsRow reports summary rows ("Totals", "Grand total") that repeat the sum of the table
+func isTotalsRow(record []string) bool {
+	first := strings.ToLower(strings.TrimSpace(record[0]))
+	return first == "total" || first == "totals" || first == "grand total"
+}
+
+// mapColumns finds the index of each known field in the header; the first alias wins
+func mapColumns(header []string) map[string]int {
+	normalized := make(map[string]int, len(header))
+	for i, name := range header {
+		var b strings.Builder
+		for _, c := range strings.ToLower(name) {
+			if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+				b.WriteRune(c)
+			}
+		}
+		if _, dup := normalized[b.String()]; !dup {
+			normalized[b.String()] = i
+		}
+	}
+
+	columns := map[string]int{}
+	for field, aliases := range columnAliases {
+		for _, alias := range aliases {
+			if i, ok := normalized[alias]; ok {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	return columns
+}
+
+func parseDate(raw string) (string, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("unrecognized date %q", raw)
+}
+
+// parseCount reads counts written with thousands separators ("1,234") or as decimals
+func parseCount(raw string) (int, error) {
+	raw = strings.NewReplacer(",", "", " ", "", " ", "").Replace(raw)
+	if raw == "" || raw == "-" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", raw)
+	}
+	return int(f + 0.5), nil
+}
+
+// normalizeKey maps the tools' labels to the values Gogol stores for native events
+func normalizeKey(dimension, raw string, website models.Website) string {
+	switch dimension {
+	case models.ImportedPages:
+		return pagePath(raw, website)
+	case models.ImportedSources:
+		switch strings.ToLower(raw) {
+		case "", "(direct)", "direct", "direct / none", "(none)", "direct entry":
+			return "Direct"
+		}
+		return raw
+	case models.ImportedCountries:
+		if name, ok := countryNames[strings.ToUpper(raw)]; ok {
+			return name
+		}
+		return raw
+	}
+	return raw
+}
+
+// pagePath turns a page label ("/blog", "blog/post", full URL) into the normalized path
+// the website's rules produce for native events
+func pagePath(raw string, website models.Website) string {
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		base, err := url.Parse(website.URL)
+		if err != nil || base.Host == "" {
+			base = &url.URL{Scheme: "https", Host: "imported.invalid"}
+		}
+		if base.Scheme == "" {
+			base.Scheme = "https"
+		}
+		raw = base.Scheme + "://" + base.Host + "/" + strings.TrimPrefix(raw, "/")
+	}
+	return urlnorm.NormalizedPath(urlnorm.StripParams(raw, website.Normalization), website.Normalization)
+}
@@ -0,0 +1,58 @@
+package statsimport
+
+import (
+	"gogol_analytics/models"
+	"strings"
+	"testing"
+)
+
+var website = models.Website{ID: "site", URL: "https://example.com", Normalization: models.DefaultNormalizationRules}
+
+func TestReadGA4Countries(t *testing.T) {
+	export := `# ----------------------------------------
+# Countries
+# ----------------------------------------
+
+Date,Country,Total users
+20240102,FR,"1,204"
+20240102,Germany,10
+
+Totals,,1214
+`
+	stats, err := Read(strings.NewReader(export), Options{Tool: "ga", Dimension: models.ImportedCountries, Website: website})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Date != "2024-01-02" || stats[0].Key != "France" || stats[0].Visitors != 1204 ||
+		stats[1].Key != "Germany" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestReadPlausiblePages(t *testing.T) {
+	export := `date,hostname,page,visits,visitors,pageviews,exits,time_on_page
+2024-01-02,example.com,/blog/,3,3,7,1,10
+2024-01-02,example.com,/blog,1,1,2,0,0
+`
+	stats, err := Read(strings.NewReader(export), Options{Tool: "plausible", Dimension: models.ImportedPages, Website: website})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Key != "/blog" || stats[0].Pageviews != 9 || stats[0].Visitors != 4 {
+		t.Errorf("expected both rows folded into /blog, got %+v", stats)
+	}
+}
+
+func TestReadMatomoPeriodExport(t *testing.T) {
+	export := "Label,Unique visitors,Visits\nGoogle,12,15\nDirect Entry,3,3\n"
+	if _, err := Read(strings.NewReader(export), Options{Tool: "matomo", Dimension: models.ImportedSources, Website: website}); err == nil {
+		t.Error("expected an error without a date column or -date")
+	}
+	stats, err := Read(strings.NewReader(export), Options{Tool: "matomo", Dimension: models.ImportedSources, Website: website, Date: "2024-01-31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[1].Key != "Direct" || stats[1].Date != "2024-01-31" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
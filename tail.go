@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gogol_analytics/controllers"
+	"gogol_analytics/database"
+	"gogol_analytics/logimport"
+	"gogol_analytics/models"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websitesRefresh is how often tailing picks up websites added on the Settings page
+const websitesRefresh = 30 * time.Second
+
+// startLogTail follows the access logs listed in GOGOL_TAIL_LOG (comma-separated) and
+// records their page views as they are written. GOGOL_TAIL_FORMAT sets the log format
+// (default "combined") and GOGOL_TAIL_SITE the website when the format has no $host.
+func startLogTail(ctx context.Context) error {
+	paths := os.Getenv("GOGOL_TAIL_LOG")
+	if paths == "" {
+		return nil
+	}
+
+	format, err := logimport.ParseFormat(os.Getenv("GOGOL_TAIL_FORMAT"))
+	if err != nil {
+		return err
+	}
+	site := os.Getenv("GOGOL_TAIL_SITE")
+
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		t := &logTail{ctx: ctx, path: path, conv: &logimport.Converter{Format: format}, site: site}
+		go func() {
+			fmt.Printf("Following access log %s\n", path)
+			if err := logimport.Follow(ctx, path, 500*time.Millisecond, t.handle); err != nil {
+				fmt.Printf("Stopped following %s: %v\n", path, err)
+			}
+			if t.dropped > 0 {
+				fmt.Printf("Log tail %s: %d page views dropped at shutdown, the event queue was full\n", path, t.dropped)
+			}
+		}()
+	}
+	return nil
+}
+
+// logTail turns the lines of one followed log into queued and broadcast events
+type logTail struct {
+	ctx       context.Context
+	path      string
+	conv      *logimport.Converter
+	site      string
+	stats     logimport.Stats
+	full      bool // The queue was full at the last page view
+	dropped   int  // Page views not queued before shutdown
+	refreshed time.Time
+	mu        sync.Mutex
+}
+
+func (t *logTail) handle(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Since(t.refreshed) > websitesRefresh {
+		websites, err := importWebsites(t.site)
+		if err != nil {
+			fmt.Printf("Log tail: %v\n", err)
+		} else {
+			t.conv.Websites = websites
+		}
+		t.refreshed = time.Now()
+	}
+
+	event, ok := t.conv.Event(line, &t.stats)
+	if !ok {
+		return
+	}
+	if !t.enqueue(event) {
+		return
+	}
+	controllers.BroadcastEvent(event)
+}
+
+// enqueue queues a page view, retrying with backoff while the queue is full. Unlike a
+// browser a log has no client that retries, but the file keeps the next lines until
+// the writer catches up, so following waits. Page views still waiting at shutdown
+// are counted in t.dropped.
+func (t *logTail) enqueue(event models.Event) bool {
+	wait := 10 * time.Millisecond
+	for {
+		err := database.EnqueueEvent(event)
+		if err == nil {
+			t.full = false
+			return true
+		}
+		if !errors.Is(err, database.ErrQueueFull) {
+			fmt.Printf("DB Error (log tail): %v\n", err)
+			return false
+		}
+		if !t.full {
+			fmt.Printf("Log tail %s: event queue full, waiting for the writer\n", t.path)
+			t.full = true
+		}
+		select {
+		case <-t.ctx.Done():
+			t.dropped++
+			return false
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, time.Second)
+	}
+}
@@ -0,0 +1,52 @@
+// Package embedded records the page views captured by the tracking middleware
+// straight into the Gogol database, for applications served by the Gogol binary itself.
+//
+//	sender, err := embedded.New("SITE_1700000000")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	handler := tracking.Middleware(mux, tracking.Config{Sender: sender})
+//
+// database.InitDB (and usually database.StartWriter) must have been called first.
+package embedded
+
+import (
+	"fmt"
+	"gogol_analytics/controllers"
+	"gogol_analytics/database"
+	"gogol_analytics/models"
+	"gogol_analytics/tracking"
+	"time"
+)
+
+// Sender enriches hits like the server-side tracking API and queues them for the database writer
+type Sender struct {
+	website models.Website
+}
+
+// New returns a sender recording hits for the website with the given ID
+func New(websiteID string) (*Sender, error) {
+	website, ok, err := database.GetWebsite(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("embedded: unknown website %q", websiteID)
+	}
+	return &Sender{website: website}, nil
+}
+
+// Send queues the hit; it is dropped when the writer's queue is full
+func (s *Sender) Send(hit tracking.Hit) {
+	err := controllers.IngestServerHit(s.website, controllers.ServerHit{
+		URL:            hit.URL,
+		Referrer:       hit.Referrer,
+		IP:             hit.IP,
+		UserAgent:      hit.UserAgent,
+		AcceptLanguage: hit.AcceptLanguage,
+		Timestamp:      hit.Timestamp,
+	}, time.Now())
+	if err != nil {
+		fmt.Printf("DB Error (embedded tracking): %v\n", err)
+	}
+}
@@ -0,0 +1,142 @@
+package tracking
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of the remote sender; batches match the server-side tracking API
+const (
+	remoteQueueSize     = 1000
+	remoteBatchSize     = 100
+	remoteFlushInterval = 2 * time.Second
+)
+
+// RemoteSender queues hits and posts them in batches to a Gogol server.
+// Hits are dropped when the queue is full or the server cannot be reached,
+// so tracking never slows the application down.
+type RemoteSender struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+	queue    chan Hit
+	done     chan struct{}
+
+	mu     sync.RWMutex // Guards closed against Send racing Close
+	closed bool
+}
+
+// Remote returns a sender for the Gogol server at baseURL (e.g. "https://analytics.example.com")
+// using the website's server-side tracking API key
+func Remote(baseURL, apiKey string) (*RemoteSender, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("tracking: base URL %q must be an absolute URL", baseURL)
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("tracking: API key is required")
+	}
+
+	s := &RemoteSender{
+		endpoint: strings.TrimRight(baseURL, "/") + "/api/server/track",
+		apiKey:   apiKey,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan Hit, remoteQueueSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Send queues a hit without waiting for the network. Hits sent after Close are dropped.
+func (s *RemoteSender) Send(hit Hit) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.queue <- hit:
+	default:
+		// Queue full: drop rather than block the request
+	}
+}
+
+// Close delivers the queued hits and stops the sender
+func (s *RemoteSender) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *RemoteSender) run() {
+	defer close(s.done)
+
+	batch := make([]Hit, 0, remoteBatchSize)
+	ticker := time.NewTicker(remoteFlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.post(batch); err != nil {
+			fmt.Printf("Gogol tracking error (%d hits dropped): %v\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case hit, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, hit)
+			if len(batch) >= remoteBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *RemoteSender) post(hits []Hit) error {
+	body, err := json.Marshal(hits)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
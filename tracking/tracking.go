@@ -0,0 +1,205 @@
+// Package tracking records page views of a Go web application without JavaScript.
+//
+// The middleware reports every successful HTML response to a Sender. Remote
+// forwards them to a Gogol server through the server-side tracking API:
+//
+//	sender, err := tracking.Remote("https://analytics.example.com", os.Getenv("GOGOL_API_KEY"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer sender.Close()
+//	http.ListenAndServe(":8080", tracking.Middleware(mux, tracking.Config{Sender: sender}))
+//
+// Applications running inside the Gogol server can write directly to its database
+// with the tracking/embedded package instead.
+package tracking
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Hit is a page view captured by the middleware. Its JSON form is the body of
+// the server-side tracking API.
+type Hit struct {
+	URL            string    `json:"url"`
+	Referrer       string    `json:"referrer,omitempty"`
+	IP             string    `json:"ip"`
+	UserAgent      string    `json:"user_agent"`
+	AcceptLanguage string    `json:"accept_language,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// Sender delivers hits. Send is called after the response has been written and
+// must not block the request for long.
+type Sender interface {
+	Send(hit Hit)
+}
+
+// DefaultExcludePaths are never tracked: health checks and well-known files.
+// Paths are matched exactly or, when they end with "/", as prefixes.
+var DefaultExcludePaths = []string{
+	"/health", "/healthz", "/livez", "/readyz", "/ready", "/ping", "/metrics",
+	"/api/health", "/favicon.ico", "/robots.txt", "/sitemap.xml",
+	"/static/", "/assets/", "/.well-known/",
+}
+
+// staticExtensions are file types served as assets rather than pages
+var staticExtensions = map[string]bool{
+	".css": true, ".js": true, ".mjs": true, ".map": true, ".json": true, ".xml": true, ".txt": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".avif": true, ".ico": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp4": true, ".webm": true, ".mp3": true, ".pdf": true, ".zip": true,
+}
+
+// Config configures the middleware
+type Config struct {
+	Sender Sender
+
+	// ExcludePaths are skipped in addition to DefaultExcludePaths
+	// (exact match, or prefix match for entries ending with "/")
+	ExcludePaths []string
+
+	// ClientIP returns the visitor's address. Defaults to the connection's remote
+	// address, also used when ClientIP returns ""; set it when the application
+	// runs behind a reverse proxy.
+	ClientIP func(r *http.Request) string
+
+	// BaseURL, e.g. "https://example.com", replaces the scheme and host of the
+	// request in tracked URLs. Defaults to the request's Host.
+	BaseURL string
+}
+
+// Middleware wraps next and reports successful GET requests answered with HTML
+func Middleware(next http.Handler, cfg Config) http.Handler {
+	if cfg.ClientIP == nil {
+		cfg.ClientIP = RemoteIP
+	}
+	exclude := append(append([]string{}, DefaultExcludePaths...), cfg.ExcludePaths...)
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Sender == nil || r.Method != http.MethodGet || IsExcluded(r.URL.Path, exclude) || isPrefetch(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		if rw.status() != http.StatusOK || !isHTML(rw.contentType) {
+			return
+		}
+		// The server needs an IP to tell visitors apart
+		ip := cfg.ClientIP(r)
+		if ip == "" {
+			ip = RemoteIP(r)
+		}
+		cfg.Sender.Send(Hit{
+			URL:            requestURL(r, baseURL),
+			Referrer:       r.Referer(),
+			IP:             ip,
+			UserAgent:      r.UserAgent(),
+			AcceptLanguage: r.Header.Get("Accept-Language"),
+			Timestamp:      time.Now(),
+		})
+	})
+}
+
+// RemoteIP returns the host part of the request's remote address
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsExcluded reports whether the path is a static asset (by file extension) or
+// matches one of the exclude paths, like DefaultExcludePaths
+func IsExcluded(p string, exclude []string) bool {
+	if staticExtensions[strings.ToLower(path.Ext(p))] {
+		return true
+	}
+	for _, e := range exclude {
+		if p == e || (strings.HasSuffix(e, "/") && strings.HasPrefix(p, e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrefetch reports speculative loads the visitor may never see
+func isPrefetch(r *http.Request) bool {
+	purpose := r.Header.Get("Sec-Purpose") + r.Header.Get("Purpose") + r.Header.Get("X-Moz")
+	return strings.Contains(purpose, "prefetch")
+}
+
+func isHTML(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "text/html")
+}
+
+func requestURL(r *http.Request, baseURL string) string {
+	if baseURL != "" {
+		return baseURL + r.URL.RequestURI()
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// responseWriter records the status code and content type of the response
+type responseWriter struct {
+	http.ResponseWriter
+	code        int
+	contentType string
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+		w.contentType = w.Header().Get("Content-Type")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+		// net/http sniffs the type of responses without a Content-Type the same way
+		w.contentType = w.Header().Get("Content-Type")
+		if w.contentType == "" {
+			w.contentType = http.DetectContentType(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// Flush forwards to the underlying writer so that streaming handlers asserting
+// http.Flusher (server-sent events, ...) keep working behind the middleware
+func (w *responseWriter) Flush() {
+	if w.code == 0 {
+		w.code = http.StatusOK
+		w.contentType = w.Header().Get("Content-Type")
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (Flush, Hijack, ...)
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,123 @@
+package tracking
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	hits []Hit
+}
+
+func (r *recorder) Send(hit Hit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hits = append(r.hits, hit)
+}
+
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE html><html><body>page</body></html>"))
+	})
+	mux.HandleFunc("/api/data", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	tests := []struct {
+		path    string
+		tracked bool
+	}{
+		{"/pricing?plan=pro", true},
+		{"/api/data", false},
+		{"/missing", false},
+		{"/healthz", false},
+		{"/static/app.html", false},
+		{"/styles/site.css", false},
+	}
+	for _, tt := range tests {
+		rec := &recorder{}
+		h := Middleware(mux, Config{Sender: rec})
+
+		req := httptest.NewRequest("GET", "http://example.com"+tt.path, nil)
+		req.Header.Set("User-Agent", "test-agent")
+		req.Header.Set("Referer", "https://search.example/?q=gogol")
+		req.RemoteAddr = "203.0.113.7:5555"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := len(rec.hits) == 1; got != tt.tracked {
+			t.Errorf("%s: tracked = %v, want %v", tt.path, got, tt.tracked)
+			continue
+		}
+		if tt.tracked {
+			hit := rec.hits[0]
+			if hit.URL != "http://example.com"+tt.path || hit.IP != "203.0.113.7" ||
+				hit.UserAgent != "test-agent" || hit.Referrer != "https://search.example/?q=gogol" {
+				t.Errorf("%s: unexpected hit %+v", tt.path, hit)
+			}
+		}
+	}
+}
+
+func TestRemoteSender(t *testing.T) {
+	var got []Hit
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/server/track" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		var batch []Hit
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		got = append(got, batch...)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := Remote(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.Send(Hit{URL: "https://example.com/a"})
+	sender.Send(Hit{URL: "https://example.com/b"})
+	sender.Close()
+
+	// Requests still in flight during shutdown must not panic
+	sender.Send(Hit{URL: "https://example.com/late"})
+	sender.Close()
+
+	if len(got) != 2 || got[1].URL != "https://example.com/b" {
+		t.Errorf("unexpected hits delivered: %+v", got)
+	}
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	rec := &recorder{}
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("expected the middleware's writer to implement http.Flusher")
+		}
+		w.Write([]byte("<p>first</p>"))
+		flusher.Flush()
+	}), Config{Sender: rec})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/stream", nil))
+	if !w.Flushed {
+		t.Error("expected Flush to reach the underlying writer")
+	}
+	if len(rec.hits) != 1 {
+		t.Errorf("expected the streamed page to be tracked, got %d hits", len(rec.hits))
+	}
+}
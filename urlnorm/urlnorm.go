@@ -0,0 +1,168 @@
+// Package urlnorm applies a website's URL normalization rules to tracked page URLs.
+package urlnorm
+
+import (
+	"gogol_analytics/models"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// StripParams removes the query parameters the rules drop for privacy (e.g. session tokens)
+// and returns the URL to store. With HashRoutes the query of a "#/route?..." fragment is
+// stripped as well. Unparsable URLs are returned unchanged.
+func StripParams(raw string, rules models.NormalizationRules) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	changed := false
+	if u.RawQuery != "" {
+		if query, ok := stripQuery(u.RawQuery, rules); ok {
+			u.RawQuery = query
+			changed = true
+		}
+	}
+	if rules.HashRoutes {
+		bang := strings.HasPrefix(u.Fragment, "!")
+		route := strings.TrimPrefix(u.Fragment, "!")
+		path, rawQuery, hasQuery := strings.Cut(route, "?")
+		if strings.HasPrefix(route, "/") && hasQuery {
+			if query, ok := stripQuery(rawQuery, rules); ok {
+				if query != "" {
+					path += "?" + query
+				}
+				if bang {
+					path = "!" + path
+				}
+				u.Fragment, u.RawFragment = path, ""
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return raw
+	}
+	return u.String()
+}
+
+// StripReferrerParams applies StripParams to a referrer on the same host as the page:
+// moving between pages of a site, the referrer is the previous page's URL and carries
+// the same parameters. Referrers of other sites are returned unchanged.
+func StripReferrerParams(referrer, page string, rules models.NormalizationRules) string {
+	host, _, _ := Split(referrer)
+	if pageHost, _, _ := Split(page); host == "" || host != pageHost {
+		return referrer
+	}
+	return StripParams(referrer, rules)
+}
+
+// stripQuery removes the dropped parameters from a raw query string and reports
+// whether any was removed
+func stripQuery(raw string, rules models.NormalizationRules) (string, bool) {
+	query, _ := url.ParseQuery(raw)
+	changed := false
+	for name := range query {
+		if dropParam(name, rules) {
+			query.Del(name)
+			changed = true
+		}
+	}
+	return query.Encode(), changed
+}
+
+// NormalizedPath returns the page identity used to group page views: the path, folded
+// according to the rules, followed by the allowlisted query parameters in sorted order.
+// Example: "https://example.com/Shop/?id=3&utm_source=x" with AllowParams [id],
+// FoldTrailingSlash and FoldCase gives "/shop?id=3". URLs without a host give "".
+func NormalizedPath(raw string, rules models.NormalizationRules) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	path := u.Path
+	query := u.Query()
+
+	// Single-page apps routing on the fragment: "/#/about?tab=1" is the page "/about"
+	if rules.HashRoutes {
+		route := strings.TrimPrefix(u.Fragment, "!")
+		if strings.HasPrefix(route, "/") {
+			routeURL, err := url.Parse(route)
+			if err == nil {
+				path = routeURL.Path
+				for name, values := range routeURL.Query() {
+					query[name] = values
+				}
+			}
+		}
+	}
+
+	if path == "" {
+		path = "/"
+	}
+	if rules.FoldTrailingSlash && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+	if rules.FoldCase {
+		path = strings.ToLower(path)
+	}
+
+	var kept []string
+	for _, name := range rules.AllowParams {
+		for _, value := range query[name] {
+			kept = append(kept, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	if len(kept) == 0 {
+		return path
+	}
+	sort.Strings(kept)
+	return path + "?" + strings.Join(kept, "&")
+}
+
+// Split breaks a URL into the parts stored as separate columns: the lowercase host
+// without port, the path ("/" when empty) and the raw query string.
+// Example: "https://Example.com:8443/blog/?page=2" gives "example.com", "/blog/", "page=2".
+// Empty or unparsable URLs give empty parts.
+func Split(raw string) (host, path, query string) {
+	if raw == "" {
+		return "", "", ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "", "", ""
+	}
+	path = u.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.ToLower(u.Hostname()), path, u.RawQuery
+}
+
+// ParseList splits a comma or whitespace separated parameter list as typed in the settings form
+func ParseList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+}
+
+func dropParam(name string, rules models.NormalizationRules) bool {
+	if rules.StripAllParams {
+		return !contains(rules.AllowParams, name)
+	}
+	return contains(rules.StripParams, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
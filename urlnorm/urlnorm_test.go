@@ -0,0 +1,86 @@
+package urlnorm
+
+import (
+	"gogol_analytics/models"
+	"testing"
+)
+
+func TestNormalizedPath(t *testing.T) {
+	cases := []struct {
+		raw   string
+		rules models.NormalizationRules
+		want  string
+	}{
+		{"https://example.com", models.NormalizationRules{}, "/"},
+		{"https://example.com/page?ref=x", models.NormalizationRules{}, "/page"},
+		{"https://example.com/page/", models.NormalizationRules{}, "/page/"},
+		{"https://example.com/page/", models.NormalizationRules{FoldTrailingSlash: true}, "/page"},
+		{"https://example.com/", models.NormalizationRules{FoldTrailingSlash: true}, "/"},
+		{"https://example.com/PAGE", models.NormalizationRules{FoldCase: true}, "/page"},
+		{"https://example.com/p?b=2&a=1&utm_source=x", models.NormalizationRules{AllowParams: []string{"b", "a"}}, "/p?a=1&b=2"},
+		{"https://example.com/app/#/about?tab=1", models.NormalizationRules{HashRoutes: true, AllowParams: []string{"tab"}}, "/about?tab=1"},
+		{"https://example.com/app/#!/about", models.NormalizationRules{HashRoutes: true}, "/about"},
+		{"https://example.com/app/#section", models.NormalizationRules{HashRoutes: true}, "/app/"},
+	}
+	for _, c := range cases {
+		if got := NormalizedPath(c.raw, c.rules); got != c.want {
+			t.Errorf("NormalizedPath(%q, %+v) = %q, want %q", c.raw, c.rules, got, c.want)
+		}
+	}
+}
+
+func TestStripParams(t *testing.T) {
+	cases := []struct {
+		raw   string
+		rules models.NormalizationRules
+		want  string
+	}{
+		{"https://example.com/p?sid=1&q=a", models.NormalizationRules{StripParams: []string{"sid"}}, "https://example.com/p?q=a"},
+		{"https://example.com/p?sid=1&q=a", models.NormalizationRules{StripAllParams: true, AllowParams: []string{"q"}}, "https://example.com/p?q=a"},
+		{"https://example.com/p?sid=1", models.NormalizationRules{StripAllParams: true}, "https://example.com/p"},
+		{"https://example.com/p?q=a", models.NormalizationRules{}, "https://example.com/p?q=a"},
+		{"https://example.com/app/#/page?token=x&tab=1", models.NormalizationRules{HashRoutes: true, StripParams: []string{"token"}}, "https://example.com/app/#/page?tab=1"},
+		{"https://example.com/app/?sid=1#!/page?token=x", models.NormalizationRules{HashRoutes: true, StripParams: []string{"sid", "token"}}, "https://example.com/app/#!/page"},
+		{"https://example.com/app/#/page?token=x", models.NormalizationRules{StripParams: []string{"token"}}, "https://example.com/app/#/page?token=x"},
+		{"https://example.com/app/#section?token=x", models.NormalizationRules{HashRoutes: true, StripParams: []string{"token"}}, "https://example.com/app/#section?token=x"},
+	}
+	for _, c := range cases {
+		if got := StripParams(c.raw, c.rules); got != c.want {
+			t.Errorf("StripParams(%q, %+v) = %q, want %q", c.raw, c.rules, got, c.want)
+		}
+	}
+}
+
+func TestStripReferrerParams(t *testing.T) {
+	rules := models.NormalizationRules{StripParams: []string{"token"}}
+	cases := []struct {
+		referrer, page, want string
+	}{
+		{"https://example.com/account?token=secret&tab=2", "https://example.com/billing", "https://example.com/account?tab=2"},
+		{"https://Example.com:8443/a?token=secret", "https://example.com/b", "https://Example.com:8443/a"},
+		{"https://other.com/?token=theirs", "https://example.com/", "https://other.com/?token=theirs"},
+		{"", "https://example.com/", ""},
+	}
+	for _, c := range cases {
+		if got := StripReferrerParams(c.referrer, c.page, rules); got != c.want {
+			t.Errorf("StripReferrerParams(%q, %q) = %q, want %q", c.referrer, c.page, got, c.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		raw, host, path, query string
+	}{
+		{"https://Example.com:8443/blog/?page=2", "example.com", "/blog/", "page=2"},
+		{"https://www.google.com", "www.google.com", "/", ""},
+		{"", "", "", ""},
+		{"unknown", "", "", ""},
+	}
+	for _, c := range cases {
+		host, path, query := Split(c.raw)
+		if host != c.host || path != c.path || query != c.query {
+			t.Errorf("Split(%q) = %q, %q, %q; want %q, %q, %q", c.raw, host, path, query, c.host, c.path, c.query)
+		}
+	}
+}